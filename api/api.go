@@ -0,0 +1,302 @@
+// Package api provides an HTTP/JSON control plane for running simulators. Servers are addressed by their index in
+// the configuration, addresses accept decimal and 0x prefixed hex numbers:
+//
+//	GET    /servers                                         servers with their slaves
+//	PUT    /servers/{server}/slaves/{slave}/online          {"online": true}
+//	GET    /servers/{server}/slaves/{slave}/{table}/{addr}  ?quantity=n or ?datatype=F32T1234
+//	PUT    /servers/{server}/slaves/{slave}/{table}/{addr}  {"values": [1, 2]} or {"datatype": "F32T1234", "value": 15}
+//	GET    /servers/{server}/slaves/{slave}/generators
+//	POST   /servers/{server}/slaves/{slave}/generators      modbus.GeneratorConfig
+//	DELETE /servers/{server}/slaves/{slave}/generators/{index}
+//	GET    /servers/{server}/slaves/{slave}/faults
+//	POST   /servers/{server}/slaves/{slave}/faults          modbus.FaultConfig
+//	PUT    /servers/{server}/slaves/{slave}/faults/{index}  {"enabled": false}
+//	DELETE /servers/{server}/slaves/{slave}/faults/{index}
+//	GET    /traffic                                         ?limit=n, the most recent requests
+//
+// Tables are coils, discrete_inputs, input_registers and holding_registers. A read returns up to 2000 coils or discrete
+// inputs and up to 125 registers, like a modbus read request.
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rwirdemann/modsimpro"
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// trafficSize is the number of requests kept for /traffic.
+const trafficSize = 1000
+
+// Handler serves the control API of a set of servers.
+type Handler struct {
+	servers []*modsimpro.ModbusServer
+	traffic *traffic
+	mux     *http.ServeMux
+}
+
+// NewHandler creates the API handler of the servers and starts recording their traffic.
+func NewHandler(servers []*modsimpro.ModbusServer) *Handler {
+	h := &Handler{servers: servers, traffic: newTraffic(trafficSize), mux: http.NewServeMux()}
+	for i, s := range servers {
+		s.Subscribe(func(e modsimpro.Event) { h.traffic.add(i, e) })
+	}
+
+	h.mux.HandleFunc("GET /servers", h.listServers)
+	h.mux.HandleFunc("PUT /servers/{server}/slaves/{slave}/online", h.setOnline)
+	h.mux.HandleFunc("GET /servers/{server}/slaves/{slave}/generators", h.listGenerators)
+	h.mux.HandleFunc("POST /servers/{server}/slaves/{slave}/generators", h.addGenerator)
+	h.mux.HandleFunc("DELETE /servers/{server}/slaves/{slave}/generators/{index}", h.removeGenerator)
+	h.mux.HandleFunc("GET /servers/{server}/slaves/{slave}/faults", h.listFaults)
+	h.mux.HandleFunc("POST /servers/{server}/slaves/{slave}/faults", h.addFault)
+	h.mux.HandleFunc("PUT /servers/{server}/slaves/{slave}/faults/{index}", h.setFault)
+	h.mux.HandleFunc("DELETE /servers/{server}/slaves/{slave}/faults/{index}", h.removeFault)
+	h.mux.HandleFunc("GET /servers/{server}/slaves/{slave}/{table}/{address}", h.read)
+	h.mux.HandleFunc("PUT /servers/{server}/slaves/{slave}/{table}/{address}", h.write)
+	h.mux.HandleFunc("GET /traffic", h.listTraffic)
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+type serverInfo struct {
+	ID     int                     `json:"id"`
+	URL    string                  `json:"url"`
+	Addr   string                  `json:"addr,omitempty"`
+	Slaves []modsimpro.SlaveStatus `json:"slaves"`
+}
+
+func (h *Handler) listServers(w http.ResponseWriter, _ *http.Request) {
+	infos := make([]serverInfo, len(h.servers))
+	for i, s := range h.servers {
+		infos[i] = serverInfo{ID: i, URL: s.URL(), Slaves: s.Slaves()}
+		if addr := s.Addr(); addr != nil {
+			infos[i].Addr = addr.String()
+		}
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (h *Handler) setOnline(w http.ResponseWriter, r *http.Request) {
+	s, slaveID, ok := h.slave(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Online bool `json:"online"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	if body.Online {
+		s.Connect(slaveID)
+	} else {
+		s.Disconnect(slaveID)
+	}
+	writeJSON(w, http.StatusOK, modsimpro.SlaveStatus{ID: slaveID, Online: body.Online})
+}
+
+func (h *Handler) listGenerators(w http.ResponseWriter, r *http.Request) {
+	if s, slaveID, ok := h.slave(w, r); ok {
+		writeJSON(w, http.StatusOK, nonNil(s.Generators(slaveID)))
+	}
+}
+
+func (h *Handler) addGenerator(w http.ResponseWriter, r *http.Request) {
+	s, slaveID, ok := h.slave(w, r)
+	if !ok {
+		return
+	}
+
+	var config modbus.GeneratorConfig
+	if !readJSON(w, r, &config) {
+		return
+	}
+	if err := s.AddGenerator(slaveID, config); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, config)
+}
+
+func (h *Handler) removeGenerator(w http.ResponseWriter, r *http.Request) {
+	s, slaveID, ok := h.slave(w, r)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err == nil {
+		err = s.RemoveGenerator(slaveID, index)
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type faultInfo struct {
+	modbus.FaultConfig
+	Enabled bool `json:"enabled"`
+}
+
+func (h *Handler) listFaults(w http.ResponseWriter, r *http.Request) {
+	s, slaveID, ok := h.slave(w, r)
+	if !ok {
+		return
+	}
+
+	infos := []faultInfo{}
+	for _, fault := range s.Faults(slaveID) {
+		infos = append(infos, faultInfo{FaultConfig: fault.Config(), Enabled: fault.Enabled()})
+	}
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (h *Handler) addFault(w http.ResponseWriter, r *http.Request) {
+	s, slaveID, ok := h.slave(w, r)
+	if !ok {
+		return
+	}
+
+	var config modbus.FaultConfig
+	if !readJSON(w, r, &config) {
+		return
+	}
+	fault, err := s.AddFault(slaveID, config)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, faultInfo{FaultConfig: fault.Config(), Enabled: fault.Enabled()})
+}
+
+func (h *Handler) setFault(w http.ResponseWriter, r *http.Request) {
+	fault, ok := h.fault(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Enabled bool `json:"enabled"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	fault.SetEnabled(body.Enabled)
+	writeJSON(w, http.StatusOK, faultInfo{FaultConfig: fault.Config(), Enabled: fault.Enabled()})
+}
+
+func (h *Handler) removeFault(w http.ResponseWriter, r *http.Request) {
+	s, slaveID, ok := h.slave(w, r)
+	if !ok {
+		return
+	}
+
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err == nil {
+		err = s.RemoveFault(slaveID, index)
+	}
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) listTraffic(w http.ResponseWriter, r *http.Request) {
+	limit := trafficSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", v))
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, http.StatusOK, h.traffic.recent(limit))
+}
+
+// server returns the server addressed by the request's path.
+func (h *Handler) server(w http.ResponseWriter, r *http.Request) (*modsimpro.ModbusServer, bool) {
+	i, err := strconv.Atoi(r.PathValue("server"))
+	if err != nil || i < 0 || i >= len(h.servers) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown server: %s", r.PathValue("server")))
+		return nil, false
+	}
+	return h.servers[i], true
+}
+
+// slave returns the server and the id of the slave addressed by the request's path. The slave must be known to the
+// server.
+func (h *Handler) slave(w http.ResponseWriter, r *http.Request) (*modsimpro.ModbusServer, int, bool) {
+	s, ok := h.server(w, r)
+	if !ok {
+		return nil, 0, false
+	}
+
+	slaveID, err := strconv.Atoi(r.PathValue("slave"))
+	if err != nil || s.MemoryMap(slaveID) == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown slave: %s", r.PathValue("slave")))
+		return nil, 0, false
+	}
+	return s, slaveID, true
+}
+
+// fault returns the fault addressed by the request's path.
+func (h *Handler) fault(w http.ResponseWriter, r *http.Request) (*modbus.Fault, bool) {
+	s, slaveID, ok := h.slave(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	faults := s.Faults(slaveID)
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 || index >= len(faults) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown fault: %s", r.PathValue("index")))
+		return nil, false
+	}
+	return faults[index], true
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %w", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// statusOf returns the HTTP status of a memory map error.
+func statusOf(err error) int {
+	if errors.Is(err, modbus.ErrUnmappedAddress) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// nonNil turns a nil slice into an empty one, so that it is encoded as [] rather than null.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
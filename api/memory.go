@@ -0,0 +1,151 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// registerTypes maps the tables of the API to the register types of the configuration.
+var registerTypes = map[string]string{
+	"coils":             "coil",
+	"discrete_inputs":   "discrete",
+	"input_registers":   "input",
+	"holding_registers": "holding",
+}
+
+// maxQuantity limits the quantity of a read like a single modbus read request of the register type does.
+var maxQuantity = map[string]int{
+	"coil":     2000,
+	"discrete": 2000,
+	"input":    125,
+	"holding":  125,
+}
+
+type memoryValues struct {
+	Address  uint16   `json:"address"`
+	Values   []uint16 `json:"values"` // coils and discrete inputs as 0 and 1
+	Datatype string   `json:"datatype,omitempty"`
+	Value    *float64 `json:"value,omitempty"` // the values decoded with datatype
+}
+
+func (h *Handler) read(w http.ResponseWriter, r *http.Request) {
+	mm, registerType, address, ok := h.memory(w, r)
+	if !ok {
+		return
+	}
+
+	res := memoryValues{Address: address, Datatype: r.URL.Query().Get("datatype")}
+	quantity := 1
+	if res.Datatype != "" {
+		n, err := modbus.RegisterCount(res.Datatype)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		quantity = n
+	} else if v := r.URL.Query().Get("quantity"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxQuantity[registerType] {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid quantity: %s, want 1..%d", v, maxQuantity[registerType]))
+			return
+		}
+		quantity = n
+	}
+
+	var err error
+	switch registerType {
+	case "coil", "discrete":
+		read := mm.ReadCoils
+		if registerType == "discrete" {
+			read = mm.ReadDiscreteInputs
+		}
+		var bits []bool
+		if bits, err = read(address, uint16(quantity)); err == nil {
+			res.Values = make([]uint16, len(bits))
+			for i, bit := range bits {
+				if bit {
+					res.Values[i] = 1
+				}
+			}
+		}
+	case "input":
+		res.Values, err = mm.ReadInputRegs(address, uint16(quantity))
+	case "holding":
+		res.Values, err = mm.ReadHoldingRegs(address, uint16(quantity))
+	}
+	if err == nil && res.Datatype != "" {
+		var v float64
+		v, err = modbus.DecodeFloat(res.Datatype, res.Values)
+		res.Value = &v
+	}
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (h *Handler) write(w http.ResponseWriter, r *http.Request) {
+	mm, registerType, address, ok := h.memory(w, r)
+	if !ok {
+		return
+	}
+
+	var body memoryValues
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	var err error
+	switch {
+	case body.Value != nil:
+		datatype := body.Datatype
+		if datatype == "" {
+			datatype = "U16"
+		}
+		err = mm.WriteValue(registerType, address, datatype, *body.Value)
+	case registerType == "coil" || registerType == "discrete":
+		bits := make([]bool, len(body.Values))
+		for i, v := range body.Values {
+			bits[i] = v != 0
+		}
+		if registerType == "coil" {
+			err = mm.WriteCoils(address, bits)
+		} else {
+			err = mm.WriteDiscreteInputs(address, bits)
+		}
+	case registerType == "input":
+		err = mm.WriteInputRegs(address, body.Values)
+	default:
+		err = mm.WriteHoldingRegs(address, body.Values)
+	}
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// memory returns the memory map, the register type of the table and the address addressed by the request's path.
+func (h *Handler) memory(w http.ResponseWriter, r *http.Request) (*modbus.MemoryMap, string, uint16, bool) {
+	s, slaveID, ok := h.slave(w, r)
+	if !ok {
+		return nil, "", 0, false
+	}
+
+	registerType, ok := registerTypes[r.PathValue("table")]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown table: %s", r.PathValue("table")))
+		return nil, "", 0, false
+	}
+
+	address, err := strconv.ParseUint(r.PathValue("address"), 0, 16)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid address: %s", r.PathValue("address")))
+		return nil, "", 0, false
+	}
+	return s.MemoryMap(slaveID), registerType, uint16(address), true
+}
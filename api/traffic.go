@@ -0,0 +1,50 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/rwirdemann/modsimpro"
+)
+
+// trafficEvent is a request received by the server with the given index.
+type trafficEvent struct {
+	Server int `json:"server"`
+	modsimpro.Event
+}
+
+// traffic keeps the most recent requests of all servers in a ring buffer.
+type traffic struct {
+	lock   sync.Mutex
+	events []trafficEvent
+	next   int
+	full   bool
+}
+
+func newTraffic(size int) *traffic {
+	return &traffic{events: make([]trafficEvent, size)}
+}
+
+func (t *traffic) add(server int, e modsimpro.Event) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.events[t.next] = trafficEvent{Server: server, Event: e}
+	t.next = (t.next + 1) % len(t.events)
+	if t.next == 0 {
+		t.full = true
+	}
+}
+
+// recent returns up to limit of the most recent requests, oldest first.
+func (t *traffic) recent(limit int) []trafficEvent {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ordered := t.events[:t.next]
+	if t.full {
+		ordered = append(append([]trafficEvent{}, t.events[t.next:]...), t.events[:t.next]...)
+	}
+	if len(ordered) > limit {
+		ordered = ordered[len(ordered)-limit:]
+	}
+	return append([]trafficEvent{}, ordered...)
+}
@@ -0,0 +1,78 @@
+package modsimpro
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// SetBehaviour attaches the behaviour script to the slave, replacing the slave's previous script. A nil behaviour
+// detaches it.
+func (s *ModbusServer) SetBehaviour(slaveID int, b *modbus.Behaviour) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if b == nil {
+		delete(s.behaviours, slaveID)
+		return
+	}
+	s.behaviours[slaveID] = b
+}
+
+// slaveBehaviour returns the behaviour script of the slave, nil if it has none.
+func (s *ModbusServer) slaveBehaviour(slaveID int) *modbus.Behaviour {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.behaviours[slaveID]
+}
+
+// applyBehaviour runs the slave's write rules that match the range written by req.
+func (s *ModbusServer) applyBehaviour(slaveID int, mm *modbus.MemoryMap, req *pdu) {
+	b := s.slaveBehaviour(slaveID)
+	if b == nil || !isWriteFunction(req.functionCode) {
+		return
+	}
+
+	e := decodeEvent(req)
+	registerType := "holding"
+	if req.functionCode == fcWriteSingleCoil || req.functionCode == fcWriteMultipleCoils {
+		registerType = "coil"
+	}
+	if err := b.OnWrite(mm, registerType, e.Address, e.Quantity); err != nil {
+		s.logBehaviourError(slaveID, err)
+	}
+}
+
+// runBehaviours periodically runs the due tick rules of all slaves' behaviour scripts until the server is stopped.
+func (s *ModbusServer) runBehaviours() {
+	ticker := time.NewTicker(generatorResolution)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.lock.RLock()
+			behaviours := make(map[int]*modbus.Behaviour, len(s.behaviours))
+			for slaveID, b := range s.behaviours {
+				behaviours[slaveID] = b
+			}
+			s.lock.RUnlock()
+
+			for slaveID, b := range behaviours {
+				mm, _, _ := s.slaveState(slaveID)
+				if mm == nil {
+					continue
+				}
+				if err := b.Tick(mm, now); err != nil {
+					s.logBehaviourError(slaveID, err)
+				}
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *ModbusServer) logBehaviourError(slaveID int, err error) {
+	s.logger.Append(fmt.Sprintf("%s behaviour: slave id: %d error: %v", time.Now().Format(time.DateTime), slaveID, err))
+}
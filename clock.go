@@ -0,0 +1,101 @@
+package modsimpro
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// clockTask exposes a simulated clock at an address of a slave's memory map.
+type clockTask struct {
+	slaveID int
+	config  modbus.ClockConfig
+	clock   *modbus.Clock
+}
+
+// addClock exposes a new simulated clock in the slave's memory map.
+func (s *ModbusServer) addClock(slaveID int, config modbus.ClockConfig) error {
+	clock, err := modbus.NewClock(config)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clocks = append(s.clocks, &clockTask{slaveID: slaveID, config: config, clock: clock})
+	return nil
+}
+
+// slaveClocks returns the clocks of the given slave.
+func (s *ModbusServer) slaveClocks(slaveID int) (clocks []*clockTask) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, c := range s.clocks {
+		if c.slaveID == slaveID {
+			clocks = append(clocks, c)
+		}
+	}
+	return
+}
+
+// refreshClocks writes the current time of the slave's clocks to its memory map.
+func (s *ModbusServer) refreshClocks(slaveID int, mm *modbus.MemoryMap) {
+	for _, c := range s.slaveClocks(slaveID) {
+		regs, err := modbus.EncodeTime(c.config.Encoding, c.clock.Now())
+		if err == nil {
+			err = writeClockRegisters(mm, c.config.RegisterType, c.config.Address, regs)
+		}
+		if err != nil {
+			s.logger.Append(fmt.Sprintf("%s clock: slave id: %d addr: %X error: %v", time.Now().Format(time.DateTime), slaveID, c.config.Address, err))
+		}
+	}
+}
+
+// setClocks sets the slave's clocks whose registers overlap the written range to the time now stored in their
+// registers.
+func (s *ModbusServer) setClocks(slaveID int, mm *modbus.MemoryMap, addr uint16, quantity uint16) {
+	for _, c := range s.slaveClocks(slaveID) {
+		n, _ := modbus.TimeRegisterCount(c.config.Encoding)
+		if int(addr)+int(quantity) <= int(c.config.Address) || int(c.config.Address)+n <= int(addr) {
+			continue
+		}
+
+		read := mm.ReadHoldingRegs
+		if c.config.RegisterType == "input" {
+			read = mm.ReadInputRegs
+		}
+		regs, err := read(c.config.Address, uint16(n))
+		if err != nil {
+			continue
+		}
+		t, err := modbus.DecodeTime(c.config.Encoding, regs)
+		if err != nil {
+			s.logger.Append(fmt.Sprintf("%s clock: slave id: %d addr: %X error: %v", time.Now().Format(time.DateTime), slaveID, c.config.Address, err))
+			continue
+		}
+		c.clock.Set(t)
+		s.logger.Append(fmt.Sprintf("%s clock: slave id: %d addr: %X set to %s", time.Now().Format(time.DateTime), slaveID, c.config.Address, t.Format(time.RFC3339)))
+	}
+}
+
+func writeClockRegisters(mm *modbus.MemoryMap, registerType string, addr uint16, regs []uint16) error {
+	if registerType == "input" {
+		return mm.WriteInputRegs(addr, regs)
+	}
+	return mm.WriteHoldingRegs(addr, regs)
+}
+
+// writtenRange returns the register range written by a write request.
+func writtenRange(req *pdu) (addr uint16, quantity uint16, ok bool) {
+	switch {
+	case (req.functionCode == fcWriteSingleRegister || req.functionCode == fcMaskWriteRegister) && len(req.payload) >= 2:
+		return bytesToUint16(BIG_ENDIAN, req.payload[0:2]), 1, true
+	case req.functionCode == fcWriteMultipleRegisters && len(req.payload) >= 4:
+		return bytesToUint16(BIG_ENDIAN, req.payload[0:2]), bytesToUint16(BIG_ENDIAN, req.payload[2:4]), true
+	case req.functionCode == fcReadWriteMultipleRegisters && len(req.payload) >= 8:
+		return bytesToUint16(BIG_ENDIAN, req.payload[4:6]), bytesToUint16(BIG_ENDIAN, req.payload[6:8]), true
+	default:
+		return 0, 0, false
+	}
+}
@@ -2,14 +2,13 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"log/slog"
+	"math"
 	"os"
 	"path"
 	"strconv"
@@ -26,6 +25,7 @@ import (
 const (
 	focusRegisterList = iota
 	focusRegisterInput
+	focusRegisterPicker
 	focusSlaves
 	panelHeight         = 10
 	ratioLeftPanelWidth = 0.6
@@ -49,6 +49,13 @@ var helpStyle = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{
 	Dark:  "#626262",
 }).Padding(0, 1)
 
+// stateStyles colour the last error of a port by its connection state.
+var stateStyles = map[string]lipgloss.Style{
+	modbus.StateConnected:    lipgloss.NewStyle().Foreground(lipgloss.Color("244")),
+	modbus.StateReconnecting: lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	modbus.StateFailed:       lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+}
+
 type slave struct {
 	modbus.Slave
 	url        string
@@ -78,12 +85,14 @@ func main() {
 
 	// Parse config into slave slices which is used by the view as its data model.
 	for _, serial := range config.Serial {
-		modbusPort := modbus.NewAdapter(serial)
+		modbusPort, err := modbus.NewAdapter(serial)
+		if err != nil {
+			log.Fatal(err)
+		}
 		for _, s := range serial.Slaves {
-			r := readFile(path.Join(*configPath, s.Type, "register.dsl"))
-			register, err := parseRegisterDSL(r, s.Address)
+			register, err := modbus.LoadRegisterDSL(path.Join(*configPath, s.Type, "register.dsl"), s.Address)
 			if err != nil {
-				panic(err)
+				log.Fatal(err)
 			}
 			slaves = append(slaves, slave{
 				Slave:      s,
@@ -111,6 +120,7 @@ func main() {
 type modbusPort interface {
 	ReadRegister(register []modbus.Register) []modbus.Register
 	WriteRegister(register modbus.Register) error
+	State() modbus.AdapterState
 	Close()
 }
 
@@ -121,6 +131,7 @@ type model struct {
 	register         []modbus.Register
 	currentRegister  modbus.Register
 	registerInput    textinput.Model
+	picker           picker
 	fullHeight       int
 	fullWidth        int
 	leftPanelWidth   int
@@ -144,10 +155,12 @@ func newModel() model {
 	columns := []table.Column{
 		{Title: "Slave", Width: 6},
 		{Title: "Address", Width: 8},
+		{Title: "Name", Width: 24},
 		{Title: "Action", Width: 6},
 		{Title: "Datatype", Width: 10},
 		{Title: "Type", Width: 10},
-		{Title: "Value", Width: 10},
+		{Title: "Value", Width: 20},
+		{Title: "Unit", Width: 6},
 	}
 
 	registers := slaves[0].modbusPort.ReadRegister(slaves[0].Registers)
@@ -163,6 +176,7 @@ func newModel() model {
 		{Title: "URL", Width: 20},
 		{Title: "Address", Width: 5},
 		{Title: "Updated", Width: 9},
+		{Title: "State", Width: 12},
 	}
 	slaveTable := table.New(
 		table.WithColumns(propertyColumns),
@@ -178,7 +192,7 @@ func newModel() model {
 func slavesToTableRows() []table.Row {
 	var rows []table.Row
 	for _, s := range slaves {
-		r := table.Row{s.url, fmt.Sprintf("%d", s.Address), time.Now().Format("15:04:05")}
+		r := table.Row{s.url, fmt.Sprintf("%d", s.Address), time.Now().Format("15:04:05"), s.modbusPort.State().State}
 		rows = append(rows, r)
 	}
 	return rows
@@ -233,8 +247,20 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "q", "ctrl+c":
 				return m, tea.Quit
 			case "enter":
+				if !m.register[m.registerTable.Cursor()].Writable() {
+					break
+				}
 				m.currentRegister = m.register[m.registerTable.Cursor()]
-				m.registerInput.SetValue(fmt.Sprintf("%v", m.currentRegister.RawData))
+				if len(m.currentRegister.Enum) > 0 || len(m.currentRegister.Flags) > 0 {
+					m.picker = newPicker(m.currentRegister)
+					m.registerTable.Blur()
+					m.focus = focusRegisterPicker
+					break
+				}
+				m.registerInput.SetValue("")
+				if m.currentRegister.Err == nil {
+					m.registerInput.SetValue(m.currentRegister.FormatValue())
+				}
 				m.registerInput.SetCursor(len(m.registerInput.Value()))
 				m.registerInput.Focus()
 				m.registerTable.Blur()
@@ -268,25 +294,45 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.focus = focusRegisterList
 			case "enter":
 				switch m.currentRegister.RegisterType {
-				case "discrete":
-					m.currentRegister.Datatype = "BOOL"
+				case "coil":
 					m.currentRegister.RawData = toBool(m.registerInput.Value())
-				case "holding", "input":
-					switch m.currentRegister.Datatype {
-					case "T64T1234":
-						m.currentRegister.RawData = toUnt64(m.registerInput.Value())
-					case "F32T1234":
-						m.currentRegister.RawData = toFloat32(m.registerInput.Value())
-					case "F32T3412":
-						m.currentRegister.RawData = toFloat32(m.registerInput.Value())
+				case "holding":
+					if modbus.IsStringDatatype(m.currentRegister.Datatype) {
+						m.currentRegister.RawData = m.registerInput.Value()
+						break
 					}
+					raw, err := toRaw(m.currentRegister, m.registerInput.Value())
+					if err != nil {
+						slog.Error(err.Error())
+						m.registerTable.Focus()
+						m.focus = focusRegisterList
+						return m, tea.Batch(cmds...)
+					}
+					m.currentRegister.RawData = toRawData(m.currentRegister.Datatype, raw)
+				}
+				err := slaves[m.slaveTable.Cursor()].modbusPort.WriteRegister(m.currentRegister)
+				if err != nil {
+					slog.Error(err.Error())
 				}
+				m.registerTable.Focus()
+				m.focus = focusRegisterList
+			}
+
+		case focusRegisterPicker:
+			switch msg.String() {
+			case "esc":
+				m.registerTable.Focus()
+				m.focus = focusRegisterList
+			case "enter":
+				m.currentRegister.RawData = toRawData(m.currentRegister.Datatype, float64(m.picker.value()))
 				err := slaves[m.slaveTable.Cursor()].modbusPort.WriteRegister(m.currentRegister)
 				if err != nil {
 					slog.Error(err.Error())
 				}
 				m.registerTable.Focus()
 				m.focus = focusRegisterList
+			default:
+				m.picker.update(msg.String())
 			}
 		}
 	case tickMsg:
@@ -321,25 +367,45 @@ func (m model) renderRegisterTable() string {
 
 func (m model) renderRegisterForm() string {
 	var style lipgloss.Style
-	if m.focus == focusRegisterInput {
+	if m.focus == focusRegisterInput || m.focus == focusRegisterPicker {
 		style = activeStyle
 	} else {
 		style = passiveStyle
 	}
 
 	s := ""
-	if m.focus == focusRegisterInput {
-		s = fmt.Sprintf("\nAddress: 0x%X\n", m.currentRegister.Address)
-		s = fmt.Sprintf("%sType   : %s\n\n", s, m.currentRegister.RegisterType)
-		m.registerInput.Prompt = "Value  : "
-		s += m.registerInput.View()
+	help := "enter - save • esc - discard"
+	if m.focus == focusRegisterInput || m.focus == focusRegisterPicker {
+		r := m.currentRegister
+		s = fmt.Sprintf("\nAddress: 0x%X\n", r.Address)
+		s = fmt.Sprintf("%sType   : %s\n", s, r.RegisterType)
+		if r.Name != "" {
+			s = fmt.Sprintf("%sName   : %s\n", s, r.Label())
+		}
+		if r.Description != "" {
+			s = fmt.Sprintf("%sInfo   : %s\n", s, r.Description)
+		}
+		if r.Min != nil || r.Max != nil {
+			s = fmt.Sprintf("%sRange  : %s\n", s, formatBounds(r))
+		}
+		s += "\n"
+		if m.focus == focusRegisterPicker {
+			s += m.picker.view()
+			help = "↑/↓ - select • enter - save • esc - discard"
+			if m.picker.multi {
+				help = "↑/↓ - select • space - toggle • enter - save • esc - discard"
+			}
+		} else {
+			m.registerInput.Prompt = "Value  : "
+			s += m.registerInput.View()
+		}
 	}
 
 	style = style.Border(generateBorder("Edit Register", m.rightPanelWidth))
 	return lipgloss.JoinVertical(
 		lipgloss.Top,
 		style.Padding(0, 1).Height(m.editPanelHeight).Width(m.rightPanelWidth).Render(s),
-		helpStyle.Render("enter - save • esc - discard"))
+		helpStyle.Render(help))
 }
 
 func (m model) renderConfigTable() string {
@@ -349,7 +415,11 @@ func (m model) renderConfigTable() string {
 	} else {
 		style = passiveStyle
 	}
-	return style.Height(m.slavePanelHeight).Width(m.rightPanelWidth).Render(m.slaveTable.View())
+	s := m.slaveTable.View()
+	if state := slaves[m.slaveTable.Cursor()].modbusPort.State(); state.LastErr != nil {
+		s += "\n" + stateStyles[state.State].Render("Error: "+state.LastErr.Error())
+	}
+	return style.Height(m.slavePanelHeight).Width(m.rightPanelWidth).Render(s)
 }
 
 func generateBorder(title string, width int) lipgloss.Border {
@@ -367,15 +437,6 @@ func init() {
 
 var config modbus.Config
 
-func toFloat32(s string) float32 {
-	f, err := strconv.ParseFloat(s, 32)
-	if err != nil {
-		slog.Error(err.Error())
-		return 0
-	}
-	return float32(f)
-}
-
 func toBool(s string) bool {
 	b, err := strconv.ParseBool(s)
 	if err != nil {
@@ -385,13 +446,37 @@ func toBool(s string) bool {
 	return b
 }
 
-func toUnt64(s string) uint64 {
-	i, err := strconv.ParseUint(s, 10, 64)
+// toRaw parses the scaled value s of the register, checks it against the register's bounds and returns the raw
+// register value.
+func toRaw(r modbus.Register, s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
 	if err != nil {
-		slog.Error(err.Error())
-		return 0
+		return 0, err
 	}
-	return i
+	if err := r.CheckRange(v); err != nil {
+		return 0, err
+	}
+	return r.Unscaled(v), nil
+}
+
+// toRawData converts a raw register value into RawData the adapter can encode as the datatype, integer datatypes are
+// rounded.
+func toRawData(datatype string, raw float64) any {
+	if modbus.IsIntegerDatatype(datatype) {
+		return math.Round(raw)
+	}
+	return raw
+}
+
+// formatBounds returns the register's bounds, e.g. "0 .. 300 V".
+func formatBounds(r modbus.Register) string {
+	bound := func(b *float64) string {
+		if b == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*b, 'f', -1, 64)
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s .. %s %s", bound(r.Min), bound(r.Max), r.Unit))
 }
 
 func registersToTableRows(registers []modbus.Register) []table.Row {
@@ -406,66 +491,11 @@ func buildTableRow(r modbus.Register) table.Row {
 	return table.Row{
 		fmt.Sprintf("%d", r.SlaveAddress),
 		fmt.Sprintf("0x%X", r.Address),
+		r.Name,
 		r.Action,
 		r.Datatype,
 		r.RegisterType,
-		fmt.Sprintf("%v", r.RawData),
-	}
-}
-
-func readFile(name string) io.Reader {
-	bb, err := os.ReadFile(name)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return bytes.NewReader(bb)
-}
-
-func parseRegisterDSL(reader io.Reader, slaveAddress uint8) ([]modbus.Register, error) {
-	dsl := readDSL(reader)
-	var registers []modbus.Register
-
-	for _, l := range dsl {
-		line := strings.Trim(l, " ")
-
-		// ignore empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		if !strings.HasPrefix(line, "read") && !strings.HasPrefix(line, "write") {
-			return nil, fmt.Errorf("register.dsl: statement '%s' doesn't start with 'read' or 'write'", line)
-		}
-		ff := strings.Fields(line)
-		if len(ff) != 6 {
-			return nil, fmt.Errorf("register.dsl: statement '%s' contains invalid keywords", line)
-		}
-		reg := modbus.Register{
-			SlaveAddress: slaveAddress,
-			Action:       ff[0],
-			Address:      parseUint16(ff[2]),
-			Datatype:     ff[4],
-			RegisterType: ff[5],
-		}
-		registers = append(registers, reg)
-	}
-
-	return registers, nil
-}
-
-func readDSL(r io.Reader) []string {
-	scanner := bufio.NewScanner(r)
-	var lines []string
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
-	}
-	return lines
-}
-
-func parseUint16(s string) uint16 {
-	i, err := strconv.ParseUint(s, 16, 16)
-	if err != nil {
-		return 0
+		r.Display(),
+		r.Unit,
 	}
-	return uint16(i)
 }
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+var severityStyles = map[string]lipgloss.Style{
+	modbus.SeverityInfo:    lipgloss.NewStyle().Foreground(lipgloss.Color("244")),
+	modbus.SeverityWarning: lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	modbus.SeverityError:   lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+}
+
+// picker selects the value of an enum register or the set flags of a bitfield register.
+type picker struct {
+	items  []pickerItem
+	cursor int
+	multi  bool // any number of items may be selected, the value is the sum of their bits
+}
+
+type pickerItem struct {
+	label    string
+	value    uint64 // the enum value or the bit mask of the flag
+	severity string
+	selected bool
+}
+
+// newPicker creates a picker for the register's enum or flags with the register's current value selected.
+func newPicker(r modbus.Register) picker {
+	var p picker
+	if len(r.Flags) > 0 {
+		p.multi = true
+		active := r.ActiveFlags()
+		for _, f := range r.Flags {
+			item := pickerItem{label: f.Name, value: 1 << f.Bit, severity: f.Severity}
+			for _, a := range active {
+				item.selected = item.selected || a.Bit == f.Bit
+			}
+			p.items = append(p.items, item)
+		}
+		return p
+	}
+
+	current, _ := r.EnumLabel()
+	for i, e := range r.Enum {
+		p.items = append(p.items, pickerItem{label: e.Label, value: e.Value})
+		if e.Label == current {
+			p.cursor = i
+		}
+	}
+	return p
+}
+
+// update moves the cursor or toggles the flag under the cursor.
+func (p *picker) update(key string) {
+	switch key {
+	case "up", "k":
+		if p.cursor > 0 {
+			p.cursor--
+		}
+	case "down", "j":
+		if p.cursor < len(p.items)-1 {
+			p.cursor++
+		}
+	case " ":
+		if p.multi && len(p.items) > 0 {
+			p.items[p.cursor].selected = !p.items[p.cursor].selected
+		}
+	}
+}
+
+// value returns the raw register value of the selection.
+func (p picker) value() uint64 {
+	if !p.multi {
+		if len(p.items) == 0 {
+			return 0
+		}
+		return p.items[p.cursor].value
+	}
+	var v uint64
+	for _, item := range p.items {
+		if item.selected {
+			v |= item.value
+		}
+	}
+	return v
+}
+
+func (p picker) view() string {
+	var sb strings.Builder
+	for i, item := range p.items {
+		cursor := "  "
+		if i == p.cursor {
+			cursor = "> "
+		}
+		label := item.label
+		if p.multi {
+			check := "[ ]"
+			if item.selected {
+				check = "[x]"
+			}
+			label = fmt.Sprintf("%s %s %s", check, label, severityStyles[item.severity].Render(item.severity))
+		}
+		sb.WriteString(cursor + label + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/rwirdemann/modsimpro"
+	"github.com/rwirdemann/modsimpro/api"
+)
+
+// startAPI serves the control API of the servers on addr in the background.
+func startAPI(addr string, servers []*modsimpro.ModbusServer) (*http.Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{Handler: api.NewHandler(servers)}
+	go func() {
+		if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("control api failed", "addr", addr, "error", err)
+		}
+	}()
+	return srv, nil
+}
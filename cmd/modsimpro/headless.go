@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rwirdemann/modsimpro"
+	"github.com/rwirdemann/modsimpro/modbus"
+	"github.com/rwirdemann/modsimpro/scenario"
+)
+
+// eventLogger passes the scenario runner's and the snapshot's messages to a structured logger.
+type eventLogger struct {
+	logger *slog.Logger
+}
+
+func (l eventLogger) Append(s string) {
+	l.logger.Info(s)
+}
+
+// discardLogger drops the servers' text log, which is meant for the TUI. Headless mode logs the servers' requests as
+// structured events instead, see logRequests.
+type discardLogger struct{}
+
+func (discardLogger) Append(string) {}
+
+// logRequests logs each request the server receives with its slave, function code, addressed range and outcome as
+// attributes.
+func logRequests(ms *modsimpro.ModbusServer, url string, logger *slog.Logger) {
+	ms.Subscribe(func(e modsimpro.Event) {
+		logger.Info("request",
+			"url", url,
+			"slave", e.SlaveID,
+			"fc", e.FunctionCode,
+			"address", e.Address,
+			"quantity", e.Quantity,
+			"values", e.Values,
+			"exception", e.Exception,
+			"answered", e.Answered,
+		)
+	})
+}
+
+// shutdownTimeout limits the time requests in progress may take to complete on shutdown.
+const shutdownTimeout = 5 * time.Second
+
+// runHeadless starts the servers of all configured serials without the TUI, with all slaves online. Requests are
+// logged as JSON lines with their slave, function code, address, quantity and exception as attributes to stdout or,
+// if a log path is given, appended to that file. With a snapshot path the servers' state is restored from that file,
+// with autosave it is saved there periodically and on shutdown. With an API address the control API is served on
+// that address. A given scenario is played right after the start and the servers are stopped when it has finished.
+// It returns the process' exit code: 1 if a server or the API fails to start or a register definition, behaviour
+// script or the snapshot can't be loaded, 2 if the scenario has failed, 0 after the servers have been stopped
+// otherwise.
+func runHeadless(config modbus.Config, sc *scenario.Scenario, opts options) int {
+	var out io.Writer = os.Stdout
+	if opts.logPath != "" {
+		f, err := os.OpenFile(opts.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	logger := slog.New(slog.NewJSONHandler(out, nil))
+	slog.SetDefault(logger)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	var servers []*modsimpro.ModbusServer
+	stop := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		for _, ms := range servers {
+			if err := ms.Stop(ctx); err != nil {
+				logger.Warn("failed to stop server", "error", err)
+			}
+		}
+	}
+
+	for _, serial := range config.Serial {
+		ms := modsimpro.NewModbusServer(serial, discardLogger{})
+		if ms == nil {
+			logger.Error("invalid url", "url", serial.Url)
+			stop()
+			return 1
+		}
+		logRequests(ms, serial.Url, logger)
+		if err := loadSlaveTypes(ms, serial, opts.configPath); err != nil {
+			logger.Error("failed to load slave type", "url", serial.Url, "error", err)
+			stop()
+			return 1
+		}
+		servers = append(servers, ms)
+		for _, slave := range serial.Slaves {
+			ms.Connect(int(slave.Address))
+		}
+	}
+
+	// restore before starting, so that masters never see the seed values
+	if opts.snapshotPath != "" {
+		if err := restoreSnapshot(opts.snapshotPath, servers); err != nil {
+			logger.Error("failed to restore snapshot", "path", opts.snapshotPath, "error", err)
+			stop()
+			return 1
+		}
+	}
+
+	for i, ms := range servers {
+		serial := config.Serial[i]
+		if err := ms.Start(); err != nil {
+			logger.Error("failed to start server", "url", serial.Url, "error", err)
+			stop()
+			return 1
+		}
+		logger.Info("server started", "url", serial.Url, "slaves", len(serial.Slaves))
+	}
+
+	if opts.apiAddr != "" {
+		srv, err := startAPI(opts.apiAddr, servers)
+		if err != nil {
+			logger.Error("failed to start control api", "addr", opts.apiAddr, "error", err)
+			stop()
+			return 1
+		}
+		defer srv.Close()
+		logger.Info("control api started", "addr", opts.apiAddr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reports := make(chan scenario.Report, 1)
+	if sc != nil {
+		runner := scenario.NewRunner(servers, eventLogger{logger: logger})
+		go func() { reports <- runner.Run(ctx, sc) }()
+	}
+	if opts.snapshotPath != "" && opts.autosave > 0 {
+		go autosave(ctx, opts.snapshotPath, opts.autosave, servers, eventLogger{logger: logger})
+	}
+
+	code := 0
+	select {
+	case sig := <-signals:
+		logger.Info("shutting down", "signal", sig.String())
+	case report := <-reports:
+		logger.Info("scenario finished", "name", report.Name, "passed", report.Passed, "results", report.Results)
+		if !report.Passed {
+			code = 2
+		}
+	}
+	cancel()
+	stop()
+	logger.Info("servers stopped")
+	if opts.snapshotPath != "" && opts.autosave > 0 {
+		saveSnapshot(opts.snapshotPath, servers, eventLogger{logger: logger})
+	}
+	return code
+}
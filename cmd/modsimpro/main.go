@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/bubbles/list"
@@ -13,6 +18,7 @@ import (
 	"github.com/charmbracelet/lipgloss"
 	"github.com/rwirdemann/modsimpro"
 	"github.com/rwirdemann/modsimpro/modbus"
+	"github.com/rwirdemann/modsimpro/scenario"
 	"github.com/rwirdemann/panels"
 )
 
@@ -29,12 +35,19 @@ type Slave struct {
 	URL    string
 	ID     int
 	Name   string
-	online bool
 	Server *modsimpro.ModbusServer
 }
 
 func (c Slave) Description() string {
-	return c.Name
+	description := c.Name
+	for i, fault := range c.Server.Faults(c.ID) {
+		state := "off"
+		if fault.Enabled() {
+			state = "on"
+		}
+		description += fmt.Sprintf(" %d:%s=%s", i+1, fault.Config().Type, state)
+	}
+	return description
 }
 
 func (c Slave) FilterValue() string {
@@ -43,7 +56,7 @@ func (c Slave) FilterValue() string {
 
 func (c Slave) Title() string {
 	connected := " online"
-	if !c.online {
+	if !c.Server.Online(c.ID) {
 		connected = "offline"
 	}
 	return fmt.Sprintf("%-20s %3d %-10s", c.URL, c.ID, connected)
@@ -55,6 +68,8 @@ type model struct {
 	selected      int
 	logger        *logger
 	rootPanel     *panels.Panel
+	servers       []*modsimpro.ModbusServer
+	snapshotPath  string
 }
 
 type tickMsg time.Time
@@ -75,11 +90,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		// Remove items from log panel if their number exceeds new panel height
-		if len(m.logger.items) > 0 && len(m.logger.items) > msg.Height-3 {
-			m.logger.items = m.logger.items[len(m.logger.items)-msg.Height-3:]
-		}
-
-		m.logger.maxItems = msg.Height - 3
+		m.logger.setMaxItems(msg.Height - 3)
 		m.width = msg.Width
 		m.heigth = msg.Height
 		return m, nil
@@ -93,16 +104,41 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if len(m.list.Items()) > 0 {
 				selected := m.list.SelectedItem().(Slave)
 				ts := time.Now().Format(time.DateTime)
-				if selected.online {
+				if selected.Server.Online(selected.ID) {
 					selected.Server.Disconnect(selected.ID)
 					m.logger.Append(fmt.Sprintf("%s %s:%d: disconnected", ts, selected.URL, selected.ID))
 				} else {
 					selected.Server.Connect(selected.ID)
 					m.logger.Append(fmt.Sprintf("%s %s:%d: connected", ts, selected.URL, selected.ID))
 				}
-				selected.online = !selected.online
-				return m, m.list.SetItem(m.list.Index(), selected)
+				return m, nil
+			}
+			return m, nil
+
+		case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+			// toggle the selected slave's fault with the key's number
+			if len(m.list.Items()) > 0 {
+				selected := m.list.SelectedItem().(Slave)
+				faults := selected.Server.Faults(selected.ID)
+				if i := int(keypress[0] - '1'); i < len(faults) {
+					faults[i].SetEnabled(!faults[i].Enabled())
+					state := "off"
+					if faults[i].Enabled() {
+						state = "on"
+					}
+					ts := time.Now().Format(time.DateTime)
+					m.logger.Append(fmt.Sprintf("%s %s:%d: fault %s switched %s", ts, selected.URL, selected.ID, faults[i], state))
+				}
+			}
+			return m, nil
+
+		case "s":
+			if m.snapshotPath == "" {
+				ts := time.Now().Format(time.DateTime)
+				m.logger.Append(fmt.Sprintf("%s snapshot: no snapshot file, start with -snapshot", ts))
+				return m, nil
 			}
+			saveSnapshot(m.snapshotPath, m.servers, m.logger)
 			return m, nil
 		}
 	case tickMsg:
@@ -116,22 +152,54 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m model) View() string {
-	help := helpStyle.Render("enter - connect • q - quit")
+	help := helpStyle.Render("enter - connect • 1-9 - toggle fault • s - save snapshot • q - quit")
 	return lipgloss.JoinVertical(lipgloss.Top, m.rootPanel.View(m, m.width, m.heigth), help)
 }
 
+// options are the command line options.
+type options struct {
+	configPath   string
+	logPath      string
+	apiAddr      string
+	scenarioPath string
+	snapshotPath string
+	autosave     time.Duration
+}
+
+// logger keeps the latest lines for the log panel. The servers append from their client goroutines while the TUI
+// renders, so items is guarded by lock.
 type logger struct {
+	lock     sync.Mutex
 	items    []string
 	maxItems int
 }
 
 func (l *logger) Append(s string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
 	l.items = append(l.items, s)
 	if len(l.items) > l.maxItems {
-		l.items = l.items[1:]
+		l.items = l.items[len(l.items)-max(l.maxItems, 0):]
 	}
 }
 
+// setMaxItems sets the number of lines kept and drops the oldest lines exceeding it.
+func (l *logger) setMaxItems(n int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.maxItems = n
+	if len(l.items) > max(n, 0) {
+		l.items = l.items[len(l.items)-max(n, 0):]
+	}
+}
+
+// lines returns the kept lines joined by newlines.
+func (l *logger) lines() string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return strings.Join(l.items, "\n")
+}
+
 func renderListView(m tea.Model, w, h int) string {
 	model := m.(model)
 	model.list.SetSize(w, h)
@@ -140,34 +208,77 @@ func renderListView(m tea.Model, w, h int) string {
 
 func renderLogView(m tea.Model, _, _ int) string {
 	model := m.(model)
-	if len(model.logger.items) == 0 {
-		return "logger.items is empty"
+	if s := model.logger.lines(); s != "" {
+		return s
+	}
+	return "logger.items is empty"
+}
+
+// loadSlaveTypes seeds the server's slaves with the registers of their type's register.dsl and attaches the
+// behaviour.dsl script next to it. Both files are optional.
+func loadSlaveTypes(ms *modsimpro.ModbusServer, serial modbus.Serial, configPath string) error {
+	for _, slave := range serial.Slaves {
+		dir := path.Join(configPath, slave.Type)
+		registers, err := modbus.LoadRegisterDSL(path.Join(dir, "register.dsl"), slave.Address)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := ms.SeedRegisters(int(slave.Address), registers); err != nil {
+			return fmt.Errorf("%s: %w", path.Join(dir, "register.dsl"), err)
+		}
+
+		b, err := modbus.LoadBehaviour(path.Join(dir, "behaviour.dsl"))
+		if err != nil {
+			return err
+		}
+		if b != nil {
+			ms.SetBehaviour(int(slave.Address), b)
+		}
 	}
-	return strings.Join(model.logger.items, "\n")
+	return nil
 }
 
 func main() {
-	var configPath string
-	flag.StringVar(&configPath, "config", "/Users/ralfwirdemann/go/src/neonpulse.io/modbusappgo/config", "path to the configuration directory")
+	var headless bool
+	var opts options
+	flag.StringVar(&opts.configPath, "config", "/Users/ralfwirdemann/go/src/neonpulse.io/modbusappgo/config", "path to the configuration directory")
+	flag.BoolVar(&headless, "headless", false, "run without TUI with all slaves online, e.g. in CI pipelines")
+	flag.StringVar(&opts.logPath, "log", "", "headless only: append JSON log lines to this file instead of stdout")
+	flag.StringVar(&opts.apiAddr, "api", "", "serve the HTTP control API on this address, e.g. localhost:8502")
+	flag.StringVar(&opts.scenarioPath, "scenario", "", "play this scenario file, headless: exit when it has finished")
+	flag.StringVar(&opts.snapshotPath, "snapshot", "", "restore the simulator state from this file at startup and save it there")
+	flag.DurationVar(&opts.autosave, "autosave", 0, "with -snapshot: save the state at this interval, e.g. 5m, and on exit")
 	flag.Parse()
-	if configPath == "" {
+	if opts.configPath == "" {
 		flag.PrintDefaults()
 		os.Exit(0)
 	}
 
-	config, err := modbus.LoadConfig(configPath)
+	config, err := modbus.LoadConfig(opts.configPath)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	var sc *scenario.Scenario
+	if opts.scenarioPath != "" {
+		if sc, err = scenario.Load(opts.scenarioPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if headless {
+		os.Exit(runHeadless(config, sc, opts))
+	}
+
 	logger := &logger{}
 	var connections []list.Item
+	var servers []*modsimpro.ModbusServer
 	for _, serial := range config.Serial {
-		ms := modsimpro.NewModbusServer(serial.Url, logger)
-		err := ms.Start()
-		if err != nil {
+		ms := modsimpro.NewModbusServer(serial, logger)
+		if err := loadSlaveTypes(ms, serial, opts.configPath); err != nil {
 			log.Fatal(err)
 		}
+		servers = append(servers, ms)
 
 		for _, slave := range serial.Slaves {
 			c := Slave{
@@ -180,6 +291,30 @@ func main() {
 		}
 	}
 
+	// restore before starting, so that masters never see the seed values
+	if opts.snapshotPath != "" {
+		if err := restoreSnapshot(opts.snapshotPath, servers); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, ms := range servers {
+		if err := ms.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if opts.apiAddr != "" {
+		if _, err := startAPI(opts.apiAddr, servers); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if sc != nil {
+		runner := scenario.NewRunner(servers, logger)
+		go runner.Run(context.Background(), sc)
+	}
+	if opts.snapshotPath != "" && opts.autosave > 0 {
+		go autosave(context.Background(), opts.snapshotPath, opts.autosave, servers, logger)
+	}
+
 	l := list.New(connections, list.NewDefaultDelegate(), 0, 0)
 	l.SetShowStatusBar(false)
 	l.SetFilteringEnabled(false)
@@ -190,13 +325,20 @@ func main() {
 	rootPanel.Append(panels.NewPanel(panels.LayoutDirectionNone, true, false, 0.35, renderListView))
 	rootPanel.Append(panels.NewPanel(panels.LayoutDirectionNone, true, false, 0.65, renderLogView))
 	m := model{
-		list:      l,
-		logger:    logger,
-		rootPanel: rootPanel,
+		list:         l,
+		logger:       logger,
+		rootPanel:    rootPanel,
+		servers:      servers,
+		snapshotPath: opts.snapshotPath,
 	}
 
 	p := tea.NewProgram(m, tea.WithAltScreen())
 	if _, err := p.Run(); err != nil {
 		log.Fatal(err)
 	}
+	if opts.snapshotPath != "" && opts.autosave > 0 {
+		if err := modsimpro.SaveSnapshot(opts.snapshotPath, servers); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/rwirdemann/modsimpro"
+)
+
+// restoreSnapshot restores the servers' state from the snapshot file at path. A missing file is not an error, it is
+// created by the first save.
+func restoreSnapshot(path string, servers []*modsimpro.ModbusServer) error {
+	snapshot, err := modsimpro.LoadSnapshot(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return modsimpro.RestoreSnapshot(snapshot, servers)
+}
+
+// saveSnapshot saves the servers' state to path and appends the outcome to logger.
+func saveSnapshot(path string, servers []*modsimpro.ModbusServer, logger modsimpro.Logger) {
+	ts := time.Now().Format(time.DateTime)
+	if err := modsimpro.SaveSnapshot(path, servers); err != nil {
+		logger.Append(fmt.Sprintf("%s snapshot: failed to save %s: %v", ts, path, err))
+		return
+	}
+	logger.Append(fmt.Sprintf("%s snapshot: saved %s", ts, path))
+}
+
+// autosave saves the servers' state to path every interval until ctx is cancelled.
+func autosave(ctx context.Context, path string, interval time.Duration, servers []*modsimpro.ModbusServer, logger modsimpro.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			saveSnapshot(path, servers, logger)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package modsimpro
+
+import (
+	"time"
+)
+
+// Event describes a request the server has received and how it has been answered.
+type Event struct {
+	Time         time.Time `json:"time"`
+	SlaveID      uint8     `json:"slave_id"`
+	FunctionCode uint8     `json:"function_code"`
+	Address      uint16    `json:"address"`             // the first address read or written, the write address of read/write multiple registers
+	Quantity     uint16    `json:"quantity"`            // the number of addresses read or written
+	Values       []uint16  `json:"values,omitempty"`    // the values written, coils as 0 and 1, the and and or masks of mask write register
+	Exception    uint8     `json:"exception,omitempty"` // the exception code of the response, 0 if the request succeeded
+	Executed     bool      `json:"executed"`            // whether the request has been executed against a slave's memory map
+	Answered     bool      `json:"answered"`            // whether a response has been sent
+}
+
+// IsWrite reports whether the event's request modifies the memory map.
+func (e Event) IsWrite() bool {
+	return isWriteFunction(e.FunctionCode)
+}
+
+// Subscribe registers fn to be called with an event for each request the server receives. fn is called on the
+// goroutine serving the request and must not block.
+func (s *ModbusServer) Subscribe(fn func(Event)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// publish passes the event describing the request and its response frame to all subscribers.
+func (s *ModbusServer) publish(req *pdu, res *pdu, adu []byte) {
+	s.lock.RLock()
+	subscribers := s.subscribers
+	s.lock.RUnlock()
+	if len(subscribers) == 0 {
+		return
+	}
+
+	e := decodeEvent(req)
+	e.Executed = req.executed
+	e.Answered = adu != nil
+	if res != nil && res.functionCode&0x80 != 0 && len(res.payload) > 0 {
+		e.Exception = res.payload[0]
+	}
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// decodeEvent extracts the addressed range and the written values of the request.
+func decodeEvent(req *pdu) Event {
+	e := Event{Time: time.Now(), SlaveID: req.unitId, FunctionCode: req.functionCode}
+	p := req.payload
+
+	switch req.functionCode {
+	case fcReadCoils, fcReadDiscreteInputs, fcReadHoldingRegisters, fcReadInputRegisters:
+		if len(p) >= 4 {
+			e.Address, e.Quantity = bytesToUint16(BIG_ENDIAN, p[0:2]), bytesToUint16(BIG_ENDIAN, p[2:4])
+		}
+	case fcWriteSingleCoil:
+		if len(p) >= 4 {
+			e.Address, e.Quantity = bytesToUint16(BIG_ENDIAN, p[0:2]), 1
+			e.Values = []uint16{0}
+			if bytesToUint16(BIG_ENDIAN, p[2:4]) == 0xFF00 {
+				e.Values[0] = 1
+			}
+		}
+	case fcWriteSingleRegister:
+		if len(p) >= 4 {
+			e.Address, e.Quantity = bytesToUint16(BIG_ENDIAN, p[0:2]), 1
+			e.Values = []uint16{bytesToUint16(BIG_ENDIAN, p[2:4])}
+		}
+	case fcWriteMultipleCoils:
+		if len(p) >= 5 {
+			e.Address, e.Quantity = bytesToUint16(BIG_ENDIAN, p[0:2]), bytesToUint16(BIG_ENDIAN, p[2:4])
+			if len(p[5:])*8 >= int(e.Quantity) {
+				for _, v := range decodeBools(e.Quantity, p[5:]) {
+					if v {
+						e.Values = append(e.Values, 1)
+					} else {
+						e.Values = append(e.Values, 0)
+					}
+				}
+			}
+		}
+	case fcWriteMultipleRegisters:
+		if len(p) >= 5 {
+			e.Address, e.Quantity = bytesToUint16(BIG_ENDIAN, p[0:2]), bytesToUint16(BIG_ENDIAN, p[2:4])
+			e.Values = bytesToUint16s(BIG_ENDIAN, p[5:])
+		}
+	case fcMaskWriteRegister:
+		if len(p) >= 6 {
+			e.Address, e.Quantity = bytesToUint16(BIG_ENDIAN, p[0:2]), 1
+			e.Values = []uint16{bytesToUint16(BIG_ENDIAN, p[2:4]), bytesToUint16(BIG_ENDIAN, p[4:6])}
+		}
+	case fcReadWriteMultipleRegisters:
+		if len(p) >= 9 {
+			e.Address, e.Quantity = bytesToUint16(BIG_ENDIAN, p[4:6]), bytesToUint16(BIG_ENDIAN, p[6:8])
+			e.Values = bytesToUint16s(BIG_ENDIAN, p[9:])
+		}
+	}
+	return e
+}
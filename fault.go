@@ -0,0 +1,171 @@
+package modsimpro
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// faultTask binds a fault to the responses of a slave.
+type faultTask struct {
+	slaveID int
+	fault   *modbus.Fault
+}
+
+// faultPlan collects the effects of the faults firing on a single request.
+type faultPlan struct {
+	fired       []string
+	delay       time.Duration
+	exception   uint8
+	drop        bool
+	wrongTxnId  bool
+	truncate    bool
+	corrupt     bool
+	wrongUnitId bool
+	close       bool
+}
+
+// forcedException is the error answered to requests an exception fault fires on.
+type forcedException uint8
+
+func (e forcedException) Error() string {
+	return fmt.Sprintf("forced exception %02X", uint8(e))
+}
+
+// AddFault injects a new fault into the responses of the slave.
+func (s *ModbusServer) AddFault(slaveID int, config modbus.FaultConfig) (*modbus.Fault, error) {
+	fault, err := modbus.NewFault(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = append(s.faults, &faultTask{slaveID: slaveID, fault: fault})
+	return fault, nil
+}
+
+// RemoveFault removes the slave's fault with the given index into the slave's faults.
+func (s *ModbusServer) RemoveFault(slaveID int, index int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, task := range s.faults {
+		if task.slaveID != slaveID {
+			continue
+		}
+		if index == 0 {
+			s.faults = slices.Delete(s.faults, i, i+1)
+			return nil
+		}
+		index--
+	}
+	return ErrNotFound
+}
+
+// Faults returns the faults of the slave in the order of their configuration. They can be switched on and off with
+// their SetEnabled method.
+func (s *ModbusServer) Faults(slaveID int) (faults []*modbus.Fault) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, task := range s.faults {
+		if task.slaveID == slaveID {
+			faults = append(faults, task.fault)
+		}
+	}
+	return
+}
+
+// planFaults returns the effects of the faults firing on the request. Faults only fire on requests to online slaves.
+func (s *ModbusServer) planFaults(req *pdu) (plan faultPlan) {
+	if _, online, _ := s.slaveState(int(req.unitId)); !online || req.unitId == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, fault := range s.Faults(int(req.unitId)) {
+		if !fault.Fires(req.functionCode, now) {
+			continue
+		}
+		plan.fired = append(plan.fired, fault.String())
+
+		switch config := fault.Config(); config.Type {
+		case modbus.FaultDelay:
+			plan.delay += fault.Delay()
+		case modbus.FaultException:
+			plan.exception = config.Exception
+		case modbus.FaultDrop:
+			plan.drop = true
+		case modbus.FaultWrongTxnId:
+			plan.wrongTxnId = true
+		case modbus.FaultTruncate:
+			plan.truncate = true
+		case modbus.FaultCorrupt:
+			plan.corrupt = true
+		case modbus.FaultWrongUnitId:
+			plan.wrongUnitId = true
+		case modbus.FaultClose:
+			plan.close = true
+		}
+	}
+
+	if len(plan.fired) > 0 {
+		ts := time.Now().Format(time.DateTime)
+		s.logger.Append(fmt.Sprintf("%s fault: slave id: %d fc: %X: %s", ts, req.unitId, req.functionCode, strings.Join(plan.fired, ", ")))
+	}
+	return
+}
+
+// serveRequest handles the request of a client with the given certificate role and returns the response frame
+// encoded by encode, with the faults firing on the request applied. A nil frame means that no response must be
+// sent, close that the connection must be closed after sending the frame.
+func (s *ModbusServer) serveRequest(req *pdu, role string, encode func(res *pdu) []byte) (adu []byte, close bool) {
+	plan := s.planFaults(req)
+
+	var res *pdu
+	switch {
+	case isWriteFunction(req.functionCode) && !s.mayWrite(role):
+		res = s.exceptionResponse(req, ErrIllegalFunction)
+	case plan.exception != 0:
+		s.logPDU("req", req)
+		res = s.exceptionResponse(req, forcedException(plan.exception))
+	default:
+		res = s.handleRequest(req)
+	}
+	if res == nil || plan.drop {
+		s.publish(req, res, nil)
+		return nil, false
+	}
+
+	time.Sleep(plan.delay)
+
+	if plan.corrupt && len(res.payload) > 0 {
+		payload := append([]byte(nil), res.payload...)
+		payload[rand.Intn(len(payload))] ^= 1 << rand.Intn(8)
+		res = &pdu{unitId: res.unitId, functionCode: res.functionCode, payload: payload}
+	}
+	if plan.wrongUnitId {
+		res = &pdu{unitId: res.unitId + 1, functionCode: res.functionCode, payload: res.payload}
+	}
+
+	adu = encode(res)
+	if plan.wrongTxnId && s.mbapFraming() {
+		copy(adu[0:2], uint16ToBytes(BIG_ENDIAN, bytesToUint16(BIG_ENDIAN, adu[0:2])+1))
+	}
+	if plan.truncate {
+		adu = adu[:1+rand.Intn(len(adu)-1)]
+	}
+	if plan.close {
+		adu = adu[:len(adu)/2]
+	}
+	s.publish(req, res, adu)
+	return adu, plan.close
+}
+
+// mbapFraming reports whether the server frames its messages with an MBAP header.
+func (s *ModbusServer) mbapFraming() bool {
+	return s.scheme == "tcp" || s.scheme == "tcp+tls" || s.scheme == "udp"
+}
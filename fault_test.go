@@ -0,0 +1,194 @@
+package modsimpro
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// faultServer starts a tcp server with holding register 0x10 = 42 and the fault injected into slave 1's responses.
+func faultServer(t *testing.T, config modbus.FaultConfig) (*ModbusServer, net.Conn) {
+	t.Helper()
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0"})
+	s.MemoryMap(1).PutHoldingReg(0x10, 42)
+	if _, err := s.AddFault(1, config); err != nil {
+		t.Fatal(err)
+	}
+	return s, dial(t, s, "tcp")
+}
+
+// readRaw sends the request in an MBAP frame and returns everything received until the connection is silent or
+// closed, and whether it has been closed.
+func readRaw(t *testing.T, conn net.Conn, req *pdu) (rx []byte, closed bool) {
+	t.Helper()
+	if _, err := conn.Write(assembleMBAPFrame(0x1234, req)); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	defer conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, maxTCPFrameLength)
+	for {
+		n, err := conn.Read(buf)
+		rx = append(rx, buf[:n]...)
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			return rx, false
+		case err != nil:
+			return rx, true
+		}
+	}
+}
+
+var readReg0x10 = &pdu{unitId: 1, functionCode: fcReadHoldingRegisters, payload: []byte{0, 0x10, 0, 1}}
+
+func TestFaultTypes(t *testing.T) {
+	full := assembleMBAPFrame(0x1234, &pdu{unitId: 1, functionCode: fcReadHoldingRegisters, payload: []byte{2, 0, 42}})
+
+	t.Run("delay", func(t *testing.T) {
+		_, conn := faultServer(t, modbus.FaultConfig{Type: modbus.FaultDelay, Delay: 100, Jitter: 20})
+		start := time.Now()
+		expectResponse(t, "delayed", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0, 0x10, 0, 1), fcReadHoldingRegisters, 2, 0, 42)
+		if d := time.Since(start); d < 100*time.Millisecond || d > 500*time.Millisecond {
+			t.Errorf("got response after %v, want about 100..120ms", d)
+		}
+	})
+
+	t.Run("drop", func(t *testing.T) {
+		s, conn := faultServer(t, modbus.FaultConfig{Type: modbus.FaultDrop})
+		// the request is executed, only its response is dropped
+		expectSilence(t, conn, 1, fcWriteSingleRegister, 0, 0x10, 0, 7)
+		if v, _ := s.MemoryMap(1).GetHoldingReg(0x10); v != 7 {
+			t.Errorf("got %d, want the dropped write executed", v)
+		}
+	})
+
+	t.Run("wrong transaction id", func(t *testing.T) {
+		_, conn := faultServer(t, modbus.FaultConfig{Type: modbus.FaultWrongTxnId})
+		rx, _ := readRaw(t, conn, readReg0x10)
+		want := bytes.Clone(full)
+		want[1]++
+		if !bytes.Equal(rx, want) {
+			t.Errorf("got % X, want % X", rx, want)
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		_, conn := faultServer(t, modbus.FaultConfig{Type: modbus.FaultTruncate})
+		rx, closed := readRaw(t, conn, readReg0x10)
+		if closed || len(rx) == 0 || len(rx) >= len(full) || !bytes.HasPrefix(full, rx) {
+			t.Errorf("got % X, closed %v, want a prefix of % X", rx, closed, full)
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		_, conn := faultServer(t, modbus.FaultConfig{Type: modbus.FaultCorrupt})
+		res := mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0, 0x10, 0, 1)
+		var flipped int
+		for i, b := range []byte{2, 0, 42} {
+			for x := res.payload[i] ^ b; x != 0; x &= x - 1 {
+				flipped++
+			}
+		}
+		if res.functionCode != fcReadHoldingRegisters || flipped != 1 {
+			t.Errorf("got % X, want a single flipped bit", res.payload)
+		}
+	})
+
+	t.Run("wrong unit id", func(t *testing.T) {
+		_, conn := faultServer(t, modbus.FaultConfig{Type: modbus.FaultWrongUnitId})
+		if _, err := conn.Write(assembleMBAPFrame(0x1234, readReg0x10)); err != nil {
+			t.Fatal(err)
+		}
+		res, _, err := readMBAPFrame(conn)
+		if err != nil || res.unitId != 2 {
+			t.Errorf("got %+v, %v, want unit id 2", res, err)
+		}
+	})
+
+	t.Run("exception", func(t *testing.T) {
+		s, conn := faultServer(t, modbus.FaultConfig{Type: modbus.FaultException})
+		expectResponse(t, "default exception", mbapRequest(t, conn, 1, fcWriteSingleRegister, 0, 0x10, 0, 7), 0x86, exServerDeviceFailure)
+		if v, _ := s.MemoryMap(1).GetHoldingReg(0x10); v != 42 {
+			t.Errorf("got %d, want the write not executed", v)
+		}
+
+		_, conn = faultServer(t, modbus.FaultConfig{Type: modbus.FaultException, Exception: 0x06})
+		expectResponse(t, "configured exception", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0, 0x10, 0, 1), 0x83, 0x06)
+	})
+
+	t.Run("close", func(t *testing.T) {
+		_, conn := faultServer(t, modbus.FaultConfig{Type: modbus.FaultClose})
+		rx, closed := readRaw(t, conn, readReg0x10)
+		if !closed || !bytes.Equal(rx, full[:len(full)/2]) {
+			t.Errorf("got % X, closed %v, want % X and the connection closed", rx, closed, full[:len(full)/2])
+		}
+	})
+}
+
+func TestFaultSchedule(t *testing.T) {
+	s, conn := faultServer(t, modbus.FaultConfig{Type: modbus.FaultException, FunctionCodes: []int{3}, Every: 2})
+
+	// only every second read fails, other function codes are never affected
+	for i := range 4 {
+		res := mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0, 0x10, 0, 1)
+		mbapRequest(t, conn, 1, fcWriteSingleRegister, 0, 0x10, 0, 42)
+		if i%2 == 1 {
+			expectResponse(t, "every 2nd read", res, 0x83, exServerDeviceFailure)
+		} else {
+			expectResponse(t, "read", res, fcReadHoldingRegisters, 2, 0, 42)
+		}
+	}
+
+	// disabled and removed faults don't fire
+	s.Faults(1)[0].SetEnabled(false)
+	for range 2 {
+		expectResponse(t, "disabled", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0, 0x10, 0, 1), fcReadHoldingRegisters, 2, 0, 42)
+	}
+	s.Faults(1)[0].SetEnabled(true)
+	if err := s.RemoveFault(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		expectResponse(t, "removed", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0, 0x10, 0, 1), fcReadHoldingRegisters, 2, 0, 42)
+	}
+	if err := s.RemoveFault(1, 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFaultsApplyToRTUOverTCP(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "rtuovertcp://127.0.0.1:0"})
+	if _, err := s.AddFault(1, modbus.FaultConfig{Type: modbus.FaultWrongUnitId}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddFault(1, modbus.FaultConfig{Type: modbus.FaultWrongTxnId}); err != nil {
+		t.Fatal(err)
+	}
+	conn := dial(t, s, "tcp")
+
+	// there's no transaction id in rtu frames, the crc of the changed frame is valid
+	if _, err := conn.Write(rtuFrame(1, fcReadHoldingRegisters, 0, 0x10, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	expectFrame(t, conn, rtuFrame(2, fcReadHoldingRegisters, 2, 0, 0))
+
+	// the frame is cut after the crc has been computed
+	_ = s.RemoveFault(1, 0)
+	_ = s.RemoveFault(1, 0)
+	if _, err := s.AddFault(1, modbus.FaultConfig{Type: modbus.FaultClose}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(rtuFrame(1, fcReadHoldingRegisters, 0, 0x10, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	rx, err := io.ReadAll(conn)
+	if want := rtuFrame(1, fcReadHoldingRegisters, 2, 0, 0); err != nil || !bytes.Equal(rx, want[:len(want)/2]) {
+		t.Errorf("got % X, %v, want % X", rx, err, want[:len(want)/2])
+	}
+}
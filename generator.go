@@ -0,0 +1,106 @@
+package modsimpro
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// generatorResolution is the interval at which generators are checked for pending updates.
+const generatorResolution = 50 * time.Millisecond
+
+// generatorTask drives an address of a slave's memory map with the values of a generator.
+type generatorTask struct {
+	slaveID int
+	config  modbus.GeneratorConfig
+	gen     modbus.Generator
+	started time.Time
+	next    time.Time
+}
+
+// AddGenerator binds a new generator to the slave's memory map.
+func (s *ModbusServer) AddGenerator(slaveID int, config modbus.GeneratorConfig) error {
+	gen, err := modbus.NewGenerator(config)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.generators = append(s.generators, &generatorTask{slaveID: slaveID, config: config, gen: gen, started: now, next: now})
+	return nil
+}
+
+// Generators returns the configurations of the slave's generators in the order they have been added.
+func (s *ModbusServer) Generators(slaveID int) (configs []modbus.GeneratorConfig) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, task := range s.generators {
+		if task.slaveID == slaveID {
+			configs = append(configs, task.config)
+		}
+	}
+	return
+}
+
+// RemoveGenerator removes the slave's generator with the given index into the slave's generators. The registers
+// keep their last value.
+func (s *ModbusServer) RemoveGenerator(slaveID int, index int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, task := range s.generators {
+		if task.slaveID != slaveID {
+			continue
+		}
+		if index == 0 {
+			s.generators = slices.Delete(s.generators, i, i+1)
+			return nil
+		}
+		index--
+	}
+	return ErrNotFound
+}
+
+// runGenerators periodically writes the current values of all due generators to their memory maps until the server
+// is stopped.
+func (s *ModbusServer) runGenerators() {
+	ticker := time.NewTicker(generatorResolution)
+	defer ticker.Stop()
+
+	now := time.Now()
+	for {
+		for _, task := range s.dueGenerators(now) {
+			mm, _, _ := s.slaveState(task.slaveID)
+			if mm == nil {
+				continue
+			}
+			v := task.gen.Value(now.Sub(task.started))
+			if err := mm.WriteValue(task.config.RegisterType, task.config.Address, task.config.EffectiveDatatype(), v); err != nil {
+				slog.Warn("failed to apply generator value", "slave", task.slaveID, "address", task.config.Address, "error", err)
+			}
+		}
+
+		select {
+		case now = <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// dueGenerators returns the generators whose next update is due and schedules their following update.
+func (s *ModbusServer) dueGenerators(now time.Time) (due []*generatorTask) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, task := range s.generators {
+		if now.Before(task.next) {
+			continue
+		}
+		task.next = now.Add(task.config.UpdateInterval())
+		due = append(due, task)
+	}
+	return
+}
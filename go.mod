@@ -8,6 +8,7 @@ require (
 	github.com/charmbracelet/lipgloss v1.1.0
 	github.com/rwirdemann/panels v0.0.0-20250716203631-de1efa830106
 	github.com/simonvetter/modbus v1.6.3
+	golang.org/x/sys v0.33.0
 )
 
 require (
@@ -31,6 +32,5 @@ require (
 	github.com/sahilm/fuzzy v0.1.1 // indirect
 	github.com/xo/terminfo v0.0.0-20220910002029-abceb7e1c41e // indirect
 	golang.org/x/sync v0.15.0 // indirect
-	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.3.8 // indirect
 )
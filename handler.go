@@ -0,0 +1,315 @@
+package modsimpro
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// Limits of the quantity fields as defined by the modbus application protocol specification.
+const (
+	maxReadBits       uint16 = 2000
+	maxWriteBits      uint16 = 1968
+	maxReadRegisters  uint16 = 125
+	maxWriteRegisters uint16 = 123
+	maxRWRegisters    uint16 = 121
+)
+
+// checkRange validates the quantity of a request against 1..maxQuantity and makes sure that the addressed range
+// doesn't exceed the 16-bit address space.
+func checkRange(addr uint16, quantity uint16, maxQuantity uint16) error {
+	if quantity == 0 || quantity > maxQuantity {
+		return ErrIllegalDataValue
+	}
+	if int(addr)+int(quantity) > 0x10000 {
+		return ErrIllegalDataAddress
+	}
+	return nil
+}
+
+// readBits handles read coils (0x01) and read discrete inputs (0x02) requests.
+func (s *ModbusServer) readBits(mm *modbus.MemoryMap, req *pdu) (res *pdu, err error) {
+	if len(req.payload) != 4 {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+	quantity := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	if err = checkRange(addr, quantity, maxReadBits); err != nil {
+		return
+	}
+
+	read := mm.ReadCoils
+	if req.functionCode == fcReadDiscreteInputs {
+		read = mm.ReadDiscreteInputs
+	}
+
+	values, err := read(addr, quantity)
+	if err != nil {
+		return
+	}
+
+	// assemble a response PDU
+	res = &pdu{
+		unitId:       req.unitId,
+		functionCode: req.functionCode,
+		payload:      []byte{0},
+	}
+	// byte count (1 byte for 8 coils)
+	res.payload[0] = uint8(len(values) / 8)
+	if len(values)%8 != 0 {
+		res.payload[0]++
+	}
+
+	// coil values
+	res.payload = append(res.payload, encodeBools(values)...)
+	return
+}
+
+// readRegisters handles read holding registers (0x03) and read input registers (0x04) requests.
+func (s *ModbusServer) readRegisters(mm *modbus.MemoryMap, req *pdu) (res *pdu, err error) {
+	if len(req.payload) != 4 {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+	quantity := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	if err = checkRange(addr, quantity, maxReadRegisters); err != nil {
+		return
+	}
+
+	read := mm.ReadHoldingRegs
+	if req.functionCode == fcReadInputRegisters {
+		read = mm.ReadInputRegs
+	}
+
+	values, err := read(addr, quantity)
+	if err != nil {
+		return
+	}
+
+	// assemble a response PDU
+	res = &pdu{
+		unitId:       req.unitId,
+		functionCode: req.functionCode,
+		payload:      []byte{uint8(quantity * 2)}, // byte count (2 bytes per register)
+	}
+
+	// append register values as bytes
+	for _, value := range values {
+		res.payload = append(res.payload, uint16ToBytes(BIG_ENDIAN, value)...)
+	}
+	return
+}
+
+// writeRegisterValues stores values in the holding registers starting at addr. With mirror_inputs configured the
+// values are copied to the input registers at the same addresses, so that they can be read back with function code
+// 0x04.
+func (s *ModbusServer) writeRegisterValues(mm *modbus.MemoryMap, addr uint16, values []uint16) error {
+	if err := mm.WriteHoldingRegs(addr, values); err != nil {
+		return err
+	}
+	if !s.serial.MirrorInputs {
+		return nil
+	}
+	return mm.WriteInputRegs(addr, values)
+}
+
+// writeSingleCoil handles write single coil (0x05) requests.
+func (s *ModbusServer) writeSingleCoil(mm *modbus.MemoryMap, req *pdu) (res *pdu, err error) {
+	if len(req.payload) != 4 {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+	value := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	if value != 0xFF00 && value != 0x0000 {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	mm.PutCoil(addr, value == 0xFF00)
+
+	// echo back the request
+	res = &pdu{
+		unitId:       req.unitId,
+		functionCode: req.functionCode,
+		payload:      req.payload[0:4],
+	}
+	return
+}
+
+// writeMultipleCoils handles write multiple coils (0x0F) requests.
+func (s *ModbusServer) writeMultipleCoils(mm *modbus.MemoryMap, req *pdu) (res *pdu, err error) {
+	if len(req.payload) < 6 {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+	quantity := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	byteCount := int(req.payload[4])
+	if err = checkRange(addr, quantity, maxWriteBits); err != nil {
+		return
+	}
+
+	// validate byte count
+	expectedByteCount := int(quantity) / 8
+	if quantity%8 != 0 {
+		expectedByteCount++
+	}
+	if byteCount != expectedByteCount || len(req.payload)-5 != byteCount {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	if err = mm.WriteCoils(addr, decodeBools(quantity, req.payload[5:])); err != nil {
+		return
+	}
+
+	// assemble response PDU (echo back addr and quantity)
+	res = &pdu{
+		unitId:       req.unitId,
+		functionCode: req.functionCode,
+		payload:      req.payload[0:4],
+	}
+	return
+}
+
+// writeSingleRegister handles write single register (0x06) requests.
+func (s *ModbusServer) writeSingleRegister(mm *modbus.MemoryMap, req *pdu) (res *pdu, err error) {
+	if len(req.payload) != 4 {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+	value := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	if err = s.writeRegisterValues(mm, addr, []uint16{value}); err != nil {
+		return
+	}
+
+	// echo back the request
+	res = &pdu{
+		unitId:       req.unitId,
+		functionCode: req.functionCode,
+		payload:      req.payload[0:4],
+	}
+	return
+}
+
+// writeMultipleRegisters handles write multiple registers (0x10) requests.
+func (s *ModbusServer) writeMultipleRegisters(mm *modbus.MemoryMap, req *pdu) (res *pdu, err error) {
+	if len(req.payload) < 6 {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+	quantity := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	byteCount := int(req.payload[4])
+	if err = checkRange(addr, quantity, maxWriteRegisters); err != nil {
+		return
+	}
+
+	// validate byte count
+	if byteCount != int(quantity)*2 || len(req.payload)-5 != byteCount {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	// log the write operation
+	ts := time.Now().Format(time.DateTime)
+	s.logger.Append(fmt.Sprintf("%s write: slave id: %d addr: %d quantity: %d", ts, req.unitId, addr, quantity))
+
+	if err = s.writeRegisterValues(mm, addr, bytesToUint16s(BIG_ENDIAN, req.payload[5:])); err != nil {
+		return
+	}
+
+	// assemble response PDU (echo back addr and quantity)
+	res = &pdu{
+		unitId:       req.unitId,
+		functionCode: req.functionCode,
+		payload:      req.payload[0:4],
+	}
+	return
+}
+
+// maskWriteRegister handles mask write register (0x16) requests. The new register value is computed as
+// (current AND andMask) OR (orMask AND NOT andMask).
+func (s *ModbusServer) maskWriteRegister(mm *modbus.MemoryMap, req *pdu) (res *pdu, err error) {
+	if len(req.payload) != 6 {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+	andMask := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	orMask := bytesToUint16(BIG_ENDIAN, req.payload[4:6])
+
+	value, err := mm.MaskWriteHoldingReg(addr, andMask, orMask)
+	if err != nil {
+		return
+	}
+	if s.serial.MirrorInputs {
+		mm.PutInputReg(addr, value)
+	}
+
+	// echo back the request
+	res = &pdu{
+		unitId:       req.unitId,
+		functionCode: req.functionCode,
+		payload:      req.payload[0:6],
+	}
+	return
+}
+
+// readWriteMultipleRegisters handles read/write multiple registers (0x17) requests. The write operation is performed
+// before the read.
+func (s *ModbusServer) readWriteMultipleRegisters(mm *modbus.MemoryMap, req *pdu) (res *pdu, err error) {
+	if len(req.payload) < 11 {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	readAddr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+	readQuantity := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	writeAddr := bytesToUint16(BIG_ENDIAN, req.payload[4:6])
+	writeQuantity := bytesToUint16(BIG_ENDIAN, req.payload[6:8])
+	byteCount := int(req.payload[8])
+	if err = checkRange(readAddr, readQuantity, maxReadRegisters); err != nil {
+		return
+	}
+	if err = checkRange(writeAddr, writeQuantity, maxRWRegisters); err != nil {
+		return
+	}
+
+	// validate byte count
+	if byteCount != int(writeQuantity)*2 || len(req.payload)-9 != byteCount {
+		err = ErrIllegalDataValue
+		return
+	}
+
+	if err = s.writeRegisterValues(mm, writeAddr, bytesToUint16s(BIG_ENDIAN, req.payload[9:])); err != nil {
+		return
+	}
+
+	values, err := mm.ReadHoldingRegs(readAddr, readQuantity)
+	if err != nil {
+		return
+	}
+
+	res = &pdu{
+		unitId:       req.unitId,
+		functionCode: req.functionCode,
+		payload:      []byte{uint8(readQuantity * 2)}, // byte count (2 bytes per register)
+	}
+	for _, value := range values {
+		res.payload = append(res.payload, uint16ToBytes(BIG_ENDIAN, value)...)
+	}
+	return
+}
@@ -0,0 +1,162 @@
+package modsimpro
+
+import (
+	"testing"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+func TestFunctionCodes(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0"})
+	mm := s.MemoryMap(1)
+	mm.PutHoldingReg(0x10, 0x1234)
+	mm.PutHoldingReg(0x11, 0x5678)
+	mm.PutInputReg(0x10, 0x0102)
+	for i, v := range []bool{true, false, true, true, false, false, false, false, true, true} {
+		mm.PutCoil(uint16(i), v)
+	}
+	mm.PutDiscreteInput(3, true)
+	conn := dial(t, s, "tcp")
+
+	tests := []struct {
+		name         string
+		functionCode uint8
+		payload      []byte
+		wantFC       uint8
+		want         []byte
+	}{
+		{"read coils", fcReadCoils, []byte{0, 0, 0, 10}, fcReadCoils, []byte{2, 0x0D, 0x03}},
+		{"read discrete inputs", fcReadDiscreteInputs, []byte{0, 3, 0, 1}, fcReadDiscreteInputs, []byte{1, 0x01}},
+		{"read holding registers", fcReadHoldingRegisters, []byte{0, 0x10, 0, 2}, fcReadHoldingRegisters, []byte{4, 0x12, 0x34, 0x56, 0x78}},
+		{"read input registers", fcReadInputRegisters, []byte{0, 0x10, 0, 1}, fcReadInputRegisters, []byte{2, 0x01, 0x02}},
+
+		// quantity limits
+		{"read 0 coils", fcReadCoils, []byte{0, 0, 0, 0}, 0x81, []byte{exIllegalDataValue}},
+		{"read 2000 coils", fcReadCoils, []byte{0, 0, 0x07, 0xD0}, fcReadCoils, append([]byte{250, 0x0D, 0x03}, make([]byte, 248)...)},
+		{"read 2001 coils", fcReadCoils, []byte{0, 0, 0x07, 0xD1}, 0x81, []byte{exIllegalDataValue}},
+		{"read 2001 discrete inputs", fcReadDiscreteInputs, []byte{0, 0, 0x07, 0xD1}, 0x82, []byte{exIllegalDataValue}},
+		{"read 126 holding registers", fcReadHoldingRegisters, []byte{0, 0, 0, 126}, 0x83, []byte{exIllegalDataValue}},
+		{"read 126 input registers", fcReadInputRegisters, []byte{0, 0, 0, 126}, 0x84, []byte{exIllegalDataValue}},
+		{"write 1969 coils", fcWriteMultipleCoils, append([]byte{0, 0, 0x07, 0xB1, 247}, make([]byte, 247)...), 0x8F, []byte{exIllegalDataValue}},
+		{"write 124 registers", fcWriteMultipleRegisters, []byte{0, 0, 0, 124, 248}, 0x90, []byte{exIllegalDataValue}},
+
+		// address limits
+		{"read coils beyond 0xFFFF", fcReadCoils, []byte{0xFF, 0xFF, 0, 2}, 0x81, []byte{exIllegalDataAddress}},
+		{"read last coil", fcReadCoils, []byte{0xFF, 0xFF, 0, 1}, fcReadCoils, []byte{1, 0}},
+		{"read registers beyond 0xFFFF", fcReadHoldingRegisters, []byte{0xFF, 0xF0, 0, 17}, 0x83, []byte{exIllegalDataAddress}},
+		{"write registers beyond 0xFFFF", fcWriteMultipleRegisters, []byte{0xFF, 0xFF, 0, 2, 4, 0, 1, 0, 2}, 0x90, []byte{exIllegalDataAddress}},
+		{"write coils beyond 0xFFFF", fcWriteMultipleCoils, []byte{0xFF, 0xFF, 0, 2, 1, 0x03}, 0x8F, []byte{exIllegalDataAddress}},
+
+		// malformed requests and byte counts
+		{"short read request", fcReadHoldingRegisters, []byte{0, 0, 0}, 0x83, []byte{exIllegalDataValue}},
+		{"invalid coil value", fcWriteSingleCoil, []byte{0, 1, 0x12, 0x34}, 0x85, []byte{exIllegalDataValue}},
+		{"coil byte count too small", fcWriteMultipleCoils, []byte{0, 0, 0, 10, 1, 0xFF}, 0x8F, []byte{exIllegalDataValue}},
+		{"coil values missing", fcWriteMultipleCoils, []byte{0, 0, 0, 10, 2, 0xFF}, 0x8F, []byte{exIllegalDataValue}},
+		{"register byte count odd", fcWriteMultipleRegisters, []byte{0, 0, 0, 2, 3, 0, 1, 0}, 0x90, []byte{exIllegalDataValue}},
+		{"register values missing", fcWriteMultipleRegisters, []byte{0, 0, 0, 2, 4, 0, 1}, 0x90, []byte{exIllegalDataValue}},
+		{"register values exceed byte count", fcWriteMultipleRegisters, []byte{0, 0, 0, 1, 2, 0, 1, 0, 2}, 0x90, []byte{exIllegalDataValue}},
+		{"short mask write", fcMaskWriteRegister, []byte{0, 0, 0xFF, 0xFF, 0}, 0x96, []byte{exIllegalDataValue}},
+		{"read/write byte count", fcReadWriteMultipleRegisters, []byte{0, 0, 0, 1, 0, 0, 0, 1, 4, 0, 1}, 0x97, []byte{exIllegalDataValue}},
+		{"read/write 126 read", fcReadWriteMultipleRegisters, []byte{0, 0, 0, 126, 0, 0, 0, 1, 2, 0, 1}, 0x97, []byte{exIllegalDataValue}},
+		{"read/write 122 write", fcReadWriteMultipleRegisters, []byte{0, 0, 0, 1, 0, 0, 0, 122, 244, 0, 1}, 0x97, []byte{exIllegalDataValue}},
+	}
+	for _, tt := range tests {
+		res := mbapRequest(t, conn, 1, tt.functionCode, tt.payload...)
+		expectResponse(t, tt.name, res, tt.wantFC, tt.want...)
+	}
+
+	// failed requests leave the memory map unchanged
+	if regs, _ := mm.ReadHoldingRegs(0, 2); regs[0] != 0 || regs[1] != 0 {
+		t.Errorf("failed writes changed holding registers: %v", regs)
+	}
+}
+
+func TestWriteFunctionCodes(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0"})
+	mm := s.MemoryMap(1)
+	conn := dial(t, s, "tcp")
+
+	expectResponse(t, "write single coil", mbapRequest(t, conn, 1, fcWriteSingleCoil, 0, 5, 0xFF, 0x00),
+		fcWriteSingleCoil, 0, 5, 0xFF, 0x00)
+	expectResponse(t, "write multiple coils", mbapRequest(t, conn, 1, fcWriteMultipleCoils, 0, 8, 0, 10, 2, 0x05, 0x02),
+		fcWriteMultipleCoils, 0, 8, 0, 10)
+	coils, _ := mm.ReadCoils(5, 13)
+	want := []bool{true, false, false, true, false, true, false, false, false, false, false, false, true}
+	for i := range want {
+		if coils[i] != want[i] {
+			t.Errorf("coils: got %v, want %v", coils, want)
+			break
+		}
+	}
+
+	expectResponse(t, "write single register", mbapRequest(t, conn, 1, fcWriteSingleRegister, 0, 0x20, 0xAB, 0xCD),
+		fcWriteSingleRegister, 0, 0x20, 0xAB, 0xCD)
+	expectResponse(t, "write multiple registers", mbapRequest(t, conn, 1, fcWriteMultipleRegisters, 0, 0x21, 0, 2, 4, 0, 1, 0, 2),
+		fcWriteMultipleRegisters, 0, 0x21, 0, 2)
+	if regs, _ := mm.ReadHoldingRegs(0x20, 3); regs[0] != 0xABCD || regs[1] != 1 || regs[2] != 2 {
+		t.Errorf("holding registers: got %04X", regs)
+	}
+}
+
+func TestMaskWriteRegister(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0"})
+	mm := s.MemoryMap(1)
+	conn := dial(t, s, "tcp")
+
+	tests := []struct {
+		current, and, or, want uint16
+	}{
+		// the example of the modbus specification
+		{0x0012, 0x00F2, 0x0025, 0x0017},
+		{0xFFFF, 0x0000, 0x0000, 0x0000},
+		{0x1234, 0xFFFF, 0xFFFF, 0x1234},
+		{0x1234, 0x0000, 0xABCD, 0xABCD},
+		{0x00FF, 0xFF00, 0x0F0F, 0x000F},
+	}
+	for _, tt := range tests {
+		mm.PutHoldingReg(0x30, tt.current)
+		payload := []byte{0, 0x30, byte(tt.and >> 8), byte(tt.and), byte(tt.or >> 8), byte(tt.or)}
+		expectResponse(t, "mask write", mbapRequest(t, conn, 1, fcMaskWriteRegister, payload...), fcMaskWriteRegister, payload...)
+		if v, _ := mm.GetHoldingReg(0x30); v != tt.want {
+			t.Errorf("%04X AND %04X OR %04X: got %04X, want %04X", tt.current, tt.and, tt.or, v, tt.want)
+		}
+	}
+}
+
+func TestReadWriteMultipleRegisters(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0"})
+	mm := s.MemoryMap(1)
+	mm.PutHoldingReg(0x40, 1)
+	mm.PutHoldingReg(0x41, 2)
+	mm.PutHoldingReg(0x42, 3)
+	conn := dial(t, s, "tcp")
+
+	// read 0x40..0x42, write 0x41..0x42: the read returns the written values
+	res := mbapRequest(t, conn, 1, fcReadWriteMultipleRegisters, 0, 0x40, 0, 3, 0, 0x41, 0, 2, 4, 0, 8, 0, 9)
+	expectResponse(t, "read/write", res, fcReadWriteMultipleRegisters, 6, 0, 1, 0, 8, 0, 9)
+}
+
+func TestWritesDontTouchInputRegisters(t *testing.T) {
+	for _, mirror := range []bool{false, true} {
+		s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0", Unmapped: "exception", MirrorInputs: mirror})
+		mm := s.MemoryMap(1)
+		mm.PutInputReg(0x10, 0x1111)
+		mm.PutHoldingReg(0x12, 0xFFFF)
+		conn := dial(t, s, "tcp")
+
+		mbapRequest(t, conn, 1, fcWriteSingleRegister, 0, 0x10, 0, 1)
+		mbapRequest(t, conn, 1, fcWriteMultipleRegisters, 0, 0x11, 0, 1, 2, 0, 2)
+		mbapRequest(t, conn, 1, fcMaskWriteRegister, 0, 0x12, 0, 0, 0, 3)
+		mbapRequest(t, conn, 1, fcReadWriteMultipleRegisters, 0, 0x13, 0, 1, 0, 0x13, 0, 1, 2, 0, 4)
+
+		res := mbapRequest(t, conn, 1, fcReadInputRegisters, 0, 0x10, 0, 4)
+		if mirror {
+			expectResponse(t, "mirrored input registers", res, fcReadInputRegisters, 8, 0, 1, 0, 2, 0, 3, 0, 4)
+			continue
+		}
+		expectResponse(t, "unmapped input registers", res, 0x84, exIllegalDataAddress)
+		if v, _ := mm.GetInputReg(0x10); v != 0x1111 {
+			t.Errorf("input register overwritten: %04X", v)
+		}
+	}
+}
@@ -1,178 +1,253 @@
 package modbus
 
 import (
-	"bytes"
-	"encoding/binary"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log/slog"
-	"math"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/simonvetter/modbus"
 )
 
+// Connection states of an adapter.
+const (
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	StateFailed       = "failed"
+)
+
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+	// failedAttempts is the number of failed reconnect attempts after which the connection counts as failed
+	failedAttempts = 5
+)
+
+// AdapterState is the connection state of an adapter.
+type AdapterState struct {
+	State   string // connected | reconnecting | failed
+	LastErr error  // the error that closed the connection or made the last reconnect attempt fail, nil if connected
+}
+
+// Adapter is the master side of a serial port or TCP connection. It reopens the connection after I/O errors and
+// timeouts, with a delay doubling from 0.5s up to 30s after each failed attempt. Requests made while it waits for the
+// next attempt fail immediately. The connection counts as failed after 5 failed attempts, but the adapter keeps
+// trying.
 type Adapter struct {
-	client *modbus.ModbusClient
+	client     *modbus.ModbusClient
+	maxReadGap int // see Serial.MaxReadGap
+
+	lock        sync.Mutex
+	state       AdapterState
+	attempts    int       // failed reconnect attempts since the connection was lost
+	nextAttempt time.Time // the time of the next reconnect attempt
 }
 
-func NewAdapter(serial Serial) Adapter {
-	client, err := modbus.NewClient(&modbus.ClientConfiguration{
+// NewAdapter returns an adapter for the serial's URL and opens the connection. It returns an error if the
+// configuration is invalid, e.g. the URL or the TLS files. If the connection can't be opened the adapter starts in
+// state reconnecting.
+func NewAdapter(serial Serial) (*Adapter, error) {
+	config := &modbus.ClientConfiguration{
 		URL:      serial.Url,
 		Speed:    uint(serial.Speed),
 		DataBits: uint(serial.DataBits),
 		Parity:   uint(serial.Parity),
 		StopBits: uint(serial.StopBits),
 		Timeout:  time.Duration(serial.Timeout) * time.Millisecond,
-	})
+	}
+	if serial.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(serial.TLSCert, serial.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", serial.Url, err)
+		}
+		config.TLSClientCert = &cert
+	}
+	if serial.TLSCA != "" {
+		rootCAs, err := modbus.LoadCertPool(serial.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", serial.Url, err)
+		}
+		config.TLSRootCAs = rootCAs
+	}
+
+	client, err := modbus.NewClient(config)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("%s: %w", serial.Url, err)
 	}
+	a := &Adapter{client: client, maxReadGap: serial.MaxReadGap, state: AdapterState{State: StateConnected}}
 	if err = client.Open(); err != nil {
-		panic(err)
+		a.disconnected(err)
 	}
-
-	return Adapter{client: client}
+	return a, nil
 }
 
-func (a Adapter) Close() {
+func (a *Adapter) Close() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
 	_ = a.client.Close()
 }
 
-func (a Adapter) ReadRegister(register []Register) []Register {
-	var rr []Register
-	for _, r := range register {
-		switch r.RegisterType {
-		case "holding":
-			holding, err := a.readHolding(r)
-			if err != nil {
-				slog.Error("error reading holding register", "err", err)
-				continue
-			}
-			rr = append(rr, holding)
-		case "input":
-			input, err := a.readInput(r)
-			if err != nil {
-				slog.Error("error reading input register", "err", err)
-				continue
-			}
-			rr = append(rr, input)
-		case "discrete":
-			discrete, err := a.readDiscrete(r)
-			if err != nil {
-				slog.Error("error reading discrete register", "err", err)
-				continue
-			}
-			rr = append(rr, discrete)
-		default:
-			slog.Error("unknown register type", "type", r.RegisterType)
-		}
-	}
-	return rr
+// State returns the connection state of the adapter.
+func (a *Adapter) State() AdapterState {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.state
 }
 
-func (a Adapter) WriteRegister(r Register) error {
-	if err := a.client.SetUnitId(r.SlaveAddress); err != nil {
-		return fmt.Errorf("set unit id: %w", err)
-	}
+// do calls f with the client of an open connection, reopening the connection first if it was lost and the next
+// attempt is due. If f fails with an I/O error or a timeout the connection is closed.
+func (a *Adapter) do(f func(client *modbus.ModbusClient) error) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
 
-	switch r.Datatype {
-	case "BOOL":
-		v := r.RawData.(bool)
-		if err := a.client.WriteCoil(r.Address, v); err != nil {
-			return err
-		}
-	case "F32T1234":
-		v := r.RawData.(float32)
-		if err := a.client.WriteFloat32(r.Address, v); err != nil {
-			return err
-		}
-	case "F32T3412":
-		buf := new(bytes.Buffer)
-		err := binary.Write(buf, binary.BigEndian, r.RawData)
-		if err != nil {
-			return err
-		}
-		byteArray := buf.Bytes()
-		msb := binary.BigEndian.Uint16(byteArray[0:])
-		lsb := binary.BigEndian.Uint16(byteArray[2:])
-		bits := (uint32(lsb) << 16) | uint32(msb)
-		v := math.Float32frombits(bits)
-		if err := a.client.WriteFloat32(r.Address, v); err != nil {
-			return err
+	if a.state.State != StateConnected {
+		if time.Now().Before(a.nextAttempt) {
+			return fmt.Errorf("%s: %w", a.state.State, a.state.LastErr)
 		}
-	case "T64T1234":
-		v := r.RawData.(uint64)
-		if err := a.client.WriteUint64(r.Address, v); err != nil {
+		if err := a.client.Open(); err != nil {
+			a.disconnected(err)
 			return err
 		}
-	default:
-		return fmt.Errorf("unknown datatype: %s", r.Datatype)
+		a.state = AdapterState{State: StateConnected}
+		a.attempts = 0
 	}
-	return nil
+
+	err := f(a.client)
+	if err != nil && isConnectionError(err) {
+		_ = a.client.Close()
+		a.disconnected(err)
+	}
+	return err
 }
 
-func (a Adapter) readHolding(register Register) (Register, error) {
-	if err := a.client.SetUnitId(register.SlaveAddress); err != nil {
-		return Register{}, fmt.Errorf("set unit id: %w", err)
+// disconnected records that the connection was lost or couldn't be reopened because of err and schedules the next
+// attempt.
+func (a *Adapter) disconnected(err error) {
+	if a.state.State != StateConnected {
+		a.attempts++
+	}
+	a.nextAttempt = time.Now().Add(min(minReconnectDelay<<min(a.attempts, 6), maxReconnectDelay))
+	a.state = AdapterState{State: StateReconnecting, LastErr: err}
+	if a.attempts >= failedAttempts {
+		a.state.State = StateFailed
 	}
+}
 
-	switch register.Datatype {
-	case "F32T1234":
-		v, err := a.client.ReadFloat32(register.Address, modbus.HOLDING_REGISTER)
-		if err != nil {
-			return Register{}, err
-		}
-		register.RawData = v
-		return register, nil
-	case "F32T3412":
-		bb, err := a.client.ReadRawBytes(register.Address, 4, modbus.HOLDING_REGISTER)
-		if err != nil {
-			return Register{}, err
+// isConnectionError reports whether err is an I/O error or a timeout rather than an exception of the slave.
+func isConnectionError(err error) bool {
+	for _, e := range []error{
+		modbus.ErrIllegalFunction, modbus.ErrIllegalDataAddress, modbus.ErrIllegalDataValue,
+		modbus.ErrServerDeviceFailure, modbus.ErrAcknowledge, modbus.ErrServerDeviceBusy, modbus.ErrMemoryParityError,
+		modbus.ErrGWPathUnavailable, modbus.ErrGWTargetFailedToRespond, modbus.ErrUnexpectedParameters,
+	} {
+		if errors.Is(err, e) {
+			return false
 		}
-		msb := binary.BigEndian.Uint16(bb[0:2])
-		lsb := binary.BigEndian.Uint16(bb[2:4])
-		bits := (uint32(lsb) << 16) | uint32(msb)
-		register.RawData = math.Float32frombits(bits)
-		return register, nil
-	default:
-		return Register{}, fmt.Errorf("unknown datatype: %s", register.Datatype)
 	}
+	return true
 }
 
-func (a Adapter) readInput(register Register) (Register, error) {
-	if err := a.client.SetUnitId(register.SlaveAddress); err != nil {
-		return Register{}, fmt.Errorf("set unit id: %w", err)
+// ReadRegister reads the registers and returns them with RawData set to their values or, if they can't be read, with
+// Err set to the error. Registers of the same slave and register type are read together if they are at most the
+// serial's MaxReadGap addresses apart.
+func (a *Adapter) ReadRegister(register []Register) []Register {
+	rr := slices.Clone(register)
+	requests, errs := planReads(rr, a.maxReadGap)
+	for i, err := range errs {
+		rr[i].RawData, rr[i].Err = nil, err
+	}
+	for _, req := range requests {
+		a.serve(rr, req)
 	}
+	return rr
+}
 
-	switch register.Datatype {
-	case "F32T1234", "F32T3412":
-		v, err := a.client.ReadFloat32(register.Address, modbus.INPUT_REGISTER)
-		if err != nil {
-			return Register{}, err
+// serve sends the read request and decodes its registers from the response. If a request serving several registers
+// is answered with an exception, e.g. because of unmapped addresses in a gap, the registers are read one by one so
+// that only the registers causing the exception fail.
+func (a *Adapter) serve(rr []Register, req readRequest) {
+	bits, regs, err := a.readRange(req)
+	if err != nil && len(req.registers) > 1 && !isConnectionError(err) {
+		for _, i := range req.registers {
+			n, _, _ := readSpan(rr[i])
+			a.serve(rr, readRequest{
+				slaveAddress: req.slaveAddress,
+				registerType: req.registerType,
+				address:      rr[i].Address,
+				quantity:     uint16(n),
+				registers:    []int{i},
+			})
 		}
-		register.RawData = v
-		return register, nil
-	case "T64T1234":
-		v, err := a.client.ReadUint64(register.Address, modbus.INPUT_REGISTER)
-		if err != nil {
-			return Register{}, err
+		return
+	}
+
+	for _, i := range req.registers {
+		r := &rr[i]
+		offset := int(r.Address - req.address)
+		switch {
+		case err != nil:
+			r.RawData, r.Err = nil, err
+		case bits != nil:
+			r.RawData, r.Err = bits[offset], nil
+		default:
+			r.RawData, r.Err = DecodeValue(r.Datatype, regs[offset:])
 		}
-		register.RawData = v
-		return register, nil
-	default:
-		return Register{}, fmt.Errorf("unknown datatype: %s", register.Datatype)
 	}
 }
 
-func (a Adapter) readDiscrete(register Register) (Register, error) {
-	if err := a.client.SetUnitId(register.SlaveAddress); err != nil {
-		return Register{}, fmt.Errorf("set unit id: %w", err)
-	}
+// readRange reads the addresses of the request, coils and discrete inputs as bits, holding and input registers as
+// registers.
+func (a *Adapter) readRange(req readRequest) (bits []bool, regs []uint16, err error) {
+	err = a.do(func(client *modbus.ModbusClient) (err error) {
+		if err = client.SetUnitId(req.slaveAddress); err != nil {
+			return fmt.Errorf("set unit id: %w", err)
+		}
+		switch req.registerType {
+		case "coil":
+			bits, err = client.ReadCoils(req.address, req.quantity)
+		case "discrete":
+			bits, err = client.ReadDiscreteInputs(req.address, req.quantity)
+		case "holding":
+			regs, err = client.ReadRegisters(req.address, req.quantity, modbus.HOLDING_REGISTER)
+		default: // input
+			regs, err = client.ReadRegisters(req.address, req.quantity, modbus.INPUT_REGISTER)
+		}
+		return err
+	})
+	return
+}
 
-	b, err := a.client.ReadDiscreteInput(register.Address)
-	if err != nil {
-		return Register{}, err
+// WriteRegister writes RawData to the register, a bool to a coil and a value EncodeValue accepts for r's datatype to
+// a holding register. Discrete inputs and input registers are read-only in Modbus.
+func (a *Adapter) WriteRegister(r Register) error {
+	switch r.RegisterType {
+	case "coil":
+		v, ok := r.RawData.(bool)
+		if !ok {
+			return fmt.Errorf("coil needs a bool, got %T", r.RawData)
+		}
+		return a.do(func(client *modbus.ModbusClient) error {
+			if err := client.SetUnitId(r.SlaveAddress); err != nil {
+				return fmt.Errorf("set unit id: %w", err)
+			}
+			return client.WriteCoil(r.Address, v)
+		})
+	case "holding":
+		regs, err := EncodeValue(r.Datatype, r.RawData)
+		if err != nil {
+			return err
+		}
+		return a.do(func(client *modbus.ModbusClient) error {
+			if err := client.SetUnitId(r.SlaveAddress); err != nil {
+				return fmt.Errorf("set unit id: %w", err)
+			}
+			return client.WriteRegisters(r.Address, regs)
+		})
+	case "discrete", "input":
+		return fmt.Errorf("%s registers are not writable", r.RegisterType)
+	default:
+		return fmt.Errorf("unknown register type: %s", r.RegisterType)
 	}
-	register.RawData = b
-	return register, nil
 }
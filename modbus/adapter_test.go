@@ -0,0 +1,87 @@
+package modbus_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+	"github.com/rwirdemann/modsimpro/modsimprotest"
+	sv "github.com/simonvetter/modbus"
+)
+
+func newAdapter(t *testing.T, url string, maxReadGap int) *modbus.Adapter {
+	t.Helper()
+	a, err := modbus.NewAdapter(modbus.Serial{Url: url, Timeout: 500, MaxReadGap: maxReadGap})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(a.Close)
+	return a
+}
+
+func TestReadRegisterBatches(t *testing.T) {
+	srv := modsimprotest.NewServer(t, 1)
+	srv.Slave(1).
+		Value("holding", 0x10, "F32T3412", 1.5).
+		Value("holding", 0x12, "S16", -2).
+		Value("holding", 0x15, "U16", 7).
+		Coils(3, true, false)
+
+	registers := []modbus.Register{
+		{SlaveAddress: 1, Address: 0x15, Datatype: "U16", RegisterType: "holding"},
+		{SlaveAddress: 1, Address: 0x10, Datatype: "F32T3412", RegisterType: "holding"},
+		{SlaveAddress: 1, Address: 0x12, Datatype: "S16", RegisterType: "holding"},
+		{SlaveAddress: 1, Address: 3, Datatype: "BOOL", RegisterType: "coil"},
+		{SlaveAddress: 1, Address: 4, Datatype: "BOOL", RegisterType: "coil"},
+	}
+	got := newAdapter(t, srv.URL(), 2).ReadRegister(registers)
+
+	want := []any{uint16(7), float32(1.5), int16(-2), true, false}
+	for i, r := range got {
+		if r.Err != nil || r.RawData != want[i] {
+			t.Errorf("register 0x%X: got %v (%T), %v, want %v", r.Address, r.RawData, r.RawData, r.Err, want[i])
+		}
+	}
+	// one request for the holding registers 0x10..0x15 and one for the coils
+	if events := srv.Events(); len(events) != 2 {
+		t.Errorf("got %d requests, want 2: %+v", len(events), events)
+	}
+}
+
+func TestReadRegisterFallsBackOnException(t *testing.T) {
+	srv := modsimprotest.NewServerWithConfig(t, modbus.Serial{
+		Url:      "tcp://127.0.0.1:0",
+		Unmapped: "exception",
+		Slaves:   []modbus.Slave{{Address: 1}},
+	})
+	srv.Slave(1).HoldingRegs(0x10, 1).HoldingRegs(0x12, 3)
+
+	registers := []modbus.Register{
+		{SlaveAddress: 1, Address: 0x10, Datatype: "U16", RegisterType: "holding"},
+		{SlaveAddress: 1, Address: 0x11, Datatype: "U16", RegisterType: "holding"}, // unmapped
+		{SlaveAddress: 1, Address: 0x12, Datatype: "U16", RegisterType: "holding"},
+		{SlaveAddress: 1, Address: 0x13, Datatype: "X16", RegisterType: "holding"},
+	}
+	a := newAdapter(t, srv.URL(), 0)
+	got := a.ReadRegister(registers)
+
+	if len(got) != len(registers) {
+		t.Fatalf("got %d registers, want %d", len(got), len(registers))
+	}
+	if got[0].Err != nil || got[0].RawData != uint16(1) {
+		t.Errorf("register 0x10: got %v, %v", got[0].RawData, got[0].Err)
+	}
+	if !errors.Is(got[1].Err, sv.ErrIllegalDataAddress) || got[1].RawData != nil {
+		t.Errorf("register 0x11: got %v, %v, want illegal data address", got[1].RawData, got[1].Err)
+	}
+	if got[2].Err != nil || got[2].RawData != uint16(3) {
+		t.Errorf("register 0x12: got %v, %v", got[2].RawData, got[2].Err)
+	}
+	if got[3].Err == nil {
+		t.Error("register 0x13: unknown datatype read")
+	}
+	// exceptions don't close the connection
+	if state := a.State(); state.State != modbus.StateConnected {
+		t.Errorf("got state %v", state)
+	}
+}
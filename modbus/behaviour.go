@@ -0,0 +1,737 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Behaviour is a script that lets a simulated slave react to writes and to the passing of time. Scripts are read
+// from the behaviour.dsl file next to the slave type's register.dsl and consist of rules:
+//
+//	# the measured power follows the setpoint
+//	on write holding 0x100 {
+//	    input 0x200 F32T1234 = holding 0x100 F32T1234 * 0.98
+//	}
+//
+//	# every second: count up while the pump is on, reset the counter on overflow
+//	on tick 1000 {
+//	    if coil 3 && counter < 100 {
+//	        counter = counter + 1
+//	    } else {
+//	        counter = 0
+//	    }
+//	    holding 0x10 = counter
+//	}
+//
+// "on write <type> <address>[..<address>]" rules run after the master has written to any address of the range,
+// "on tick <ms>" rules run at the given interval. Statements are assignments and if/else. A register is referenced
+// as "<type> <address> [<datatype>]" with type coil, discrete, input or holding and datatype U16 by default; coils
+// and discrete inputs are 0 or 1. Any other name is a variable that keeps its value between runs and is 0 until
+// it is first assigned. Expressions combine numbers, registers, variables and the functions abs, min, max, clamp,
+// round and random with the operators
+//
+//	||  &&  == != < <= > >=  + -  * / %  unary - !
+//
+// in the order of increasing precedence. Comparisons and logical operators yield 1 or 0, 0 is false. Writes done
+// by a script don't trigger write rules.
+type Behaviour struct {
+	lock       sync.Mutex
+	writeRules []writeRule
+	tickRules  []*tickRule
+	vars       map[string]float64
+}
+
+type writeRule struct {
+	registerType string
+	from, to     uint16
+	body         []statement
+}
+
+type tickRule struct {
+	interval time.Duration
+	next     time.Time
+	body     []statement
+}
+
+// LoadBehaviour reads and parses the behaviour script at path. It returns nil without an error if there is no such
+// file.
+func LoadBehaviour(path string) (*Behaviour, error) {
+	if !exists(path) {
+		return nil, nil
+	}
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ParseBehaviour(string(bb))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return b, nil
+}
+
+// ParseBehaviour parses a behaviour script. Errors start with the line and column of the offending token, e.g.
+// "3:12: expected "=", got "}"".
+func ParseBehaviour(src string) (*Behaviour, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	b := &Behaviour{vars: make(map[string]float64)}
+	for p.peek().kind != tokenEOF {
+		if _, err := p.expect("on"); err != nil {
+			return nil, err
+		}
+		switch t := p.next(); t.text {
+		case "write":
+			rule, err := p.parseWriteTrigger()
+			if err != nil {
+				return nil, err
+			}
+			if rule.body, err = p.parseBlock(); err != nil {
+				return nil, err
+			}
+			b.writeRules = append(b.writeRules, rule)
+		case "tick":
+			t := p.next()
+			ms, err := strconv.Atoi(t.text)
+			if t.kind != tokenNumber || err != nil || ms <= 0 {
+				return nil, p.errorf(t, "tick interval must be a positive number of ms")
+			}
+			body, err := p.parseBlock()
+			if err != nil {
+				return nil, err
+			}
+			b.tickRules = append(b.tickRules, &tickRule{interval: time.Duration(ms) * time.Millisecond, body: body})
+		default:
+			return nil, p.errorf(t, "expected write or tick, got %q", t.text)
+		}
+	}
+	return b, nil
+}
+
+// OnWrite runs the write rules whose range overlaps the quantity addresses of registerType starting at address
+// against mm.
+func (b *Behaviour) OnWrite(mm *MemoryMap, registerType string, address uint16, quantity uint16) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var errs []error
+	for _, rule := range b.writeRules {
+		if rule.registerType != registerType || int(address)+int(quantity) <= int(rule.from) || int(rule.to) < int(address) {
+			continue
+		}
+		errs = append(errs, b.run(mm, rule.body))
+	}
+	return errors.Join(errs...)
+}
+
+// Tick runs the tick rules that are due at now against mm. A rule first runs one interval after the first call.
+func (b *Behaviour) Tick(mm *MemoryMap, now time.Time) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var errs []error
+	for _, rule := range b.tickRules {
+		if rule.next.IsZero() {
+			rule.next = now.Add(rule.interval)
+		}
+		if now.Before(rule.next) {
+			continue
+		}
+		// keep to the interval's grid unless the rule has fallen behind by more than an interval
+		if rule.next = rule.next.Add(rule.interval); rule.next.Before(now) {
+			rule.next = now.Add(rule.interval)
+		}
+		errs = append(errs, b.run(mm, rule.body))
+	}
+	return errors.Join(errs...)
+}
+
+func (b *Behaviour) run(mm *MemoryMap, body []statement) error {
+	env := &scriptEnv{mm: mm, vars: b.vars}
+	for _, s := range body {
+		if err := s.exec(env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scriptEnv is the state a rule is executed with.
+type scriptEnv struct {
+	mm   *MemoryMap
+	vars map[string]float64
+}
+
+type statement interface {
+	exec(env *scriptEnv) error
+}
+
+type expression interface {
+	eval(env *scriptEnv) (float64, error)
+}
+
+type assignRegister struct {
+	target registerRef
+	value  expression
+}
+
+func (s assignRegister) exec(env *scriptEnv) error {
+	v, err := s.value.eval(env)
+	if err != nil {
+		return err
+	}
+	if err := env.mm.WriteValue(s.target.registerType, s.target.address, s.target.datatype, v); err != nil {
+		return fmt.Errorf("%s: %w", s.target.pos, err)
+	}
+	return nil
+}
+
+type assignVariable struct {
+	name  string
+	value expression
+}
+
+func (s assignVariable) exec(env *scriptEnv) error {
+	v, err := s.value.eval(env)
+	if err != nil {
+		return err
+	}
+	env.vars[s.name] = v
+	return nil
+}
+
+type ifStatement struct {
+	cond      expression
+	then      []statement
+	otherwise []statement
+}
+
+func (s ifStatement) exec(env *scriptEnv) error {
+	v, err := s.cond.eval(env)
+	if err != nil {
+		return err
+	}
+	body := s.otherwise
+	if v != 0 {
+		body = s.then
+	}
+	for _, st := range body {
+		if err := st.exec(env); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type number float64
+
+func (n number) eval(*scriptEnv) (float64, error) {
+	return float64(n), nil
+}
+
+type variable string
+
+func (v variable) eval(env *scriptEnv) (float64, error) {
+	return env.vars[string(v)], nil
+}
+
+type registerRef struct {
+	pos          string
+	registerType string
+	address      uint16
+	datatype     string
+}
+
+func (r registerRef) eval(env *scriptEnv) (float64, error) {
+	v, err := env.mm.ReadValue(r.registerType, r.address, r.datatype)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", r.pos, err)
+	}
+	return v, nil
+}
+
+type unaryOp struct {
+	op string
+	x  expression
+}
+
+func (u unaryOp) eval(env *scriptEnv) (float64, error) {
+	v, err := u.x.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if u.op == "-" {
+		return -v, nil
+	}
+	return truth(v == 0), nil
+}
+
+type binaryOp struct {
+	pos  string
+	op   string
+	l, r expression
+}
+
+func (b binaryOp) eval(env *scriptEnv) (float64, error) {
+	l, err := b.l.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	// && and || don't evaluate their right operand if the left one decides the result
+	if b.op == "&&" && l == 0 || b.op == "||" && l != 0 {
+		return truth(l != 0), nil
+	}
+	r, err := b.r.eval(env)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case "&&", "||":
+		return truth(r != 0), nil
+	case "==":
+		return truth(l == r), nil
+	case "!=":
+		return truth(l != r), nil
+	case "<":
+		return truth(l < r), nil
+	case "<=":
+		return truth(l <= r), nil
+	case ">":
+		return truth(l > r), nil
+	case ">=":
+		return truth(l >= r), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	}
+	if r == 0 {
+		return 0, fmt.Errorf("%s: division by zero", b.pos)
+	}
+	if b.op == "/" {
+		return l / r, nil
+	}
+	return math.Mod(l, r), nil
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// scriptFunction is a builtin function, arity -1 accepts one or more arguments.
+type scriptFunction struct {
+	arity int
+	fn    func(args []float64) float64
+}
+
+var scriptFunctions = map[string]scriptFunction{
+	"abs":   {1, func(args []float64) float64 { return math.Abs(args[0]) }},
+	"round": {1, func(args []float64) float64 { return math.Round(args[0]) }},
+	"clamp": {3, func(args []float64) float64 { return clamp(args[0], args[1], args[2]) }},
+	"random": {0, func([]float64) float64 {
+		return rand.Float64()
+	}},
+	"min": {-1, func(args []float64) float64 {
+		v := args[0]
+		for _, a := range args[1:] {
+			v = math.Min(v, a)
+		}
+		return v
+	}},
+	"max": {-1, func(args []float64) float64 {
+		v := args[0]
+		for _, a := range args[1:] {
+			v = math.Max(v, a)
+		}
+		return v
+	}},
+}
+
+type call struct {
+	fn   scriptFunction
+	args []expression
+}
+
+func (c call) eval(env *scriptEnv) (float64, error) {
+	args := make([]float64, len(c.args))
+	for i, a := range c.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	return c.fn.fn(args), nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind      tokenKind
+	text      string
+	line, col int
+}
+
+func (t token) pos() string {
+	return fmt.Sprintf("%d:%d", t.line, t.col)
+}
+
+// lex splits the script into tokens, skipping white space and comments.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+	line, col := 1, 1
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		start := token{line: line, col: col}
+		n := 1
+		switch {
+		case c == '\n':
+			line, col = line+1, 0
+		case unicode.IsSpace(c):
+		case c == '#':
+			for i+n < len(runes) && runes[i+n] != '\n' {
+				n++
+			}
+		case unicode.IsLetter(c) || c == '_':
+			for i+n < len(runes) && (unicode.IsLetter(runes[i+n]) || unicode.IsDigit(runes[i+n]) || runes[i+n] == '_') {
+				n++
+			}
+			start.kind = tokenIdent
+		case unicode.IsDigit(c):
+			for i+n < len(runes) && (unicode.IsDigit(runes[i+n]) || unicode.IsLetter(runes[i+n]) ||
+				runes[i+n] == '.' && i+n+1 < len(runes) && unicode.IsDigit(runes[i+n+1])) {
+				n++
+			}
+			start.kind = tokenNumber
+		case strings.ContainsRune("=!<>&|.", c) && i+1 < len(runes) && isOperator(string(runes[i:i+2])):
+			n = 2
+			start.kind = tokenPunct
+		case strings.ContainsRune("{}(),;=<>+-*/%!", c):
+			start.kind = tokenPunct
+		default:
+			return nil, fmt.Errorf("%d:%d: unexpected character %q", line, col, c)
+		}
+		if start.kind != tokenEOF {
+			start.text = string(runes[i : i+n])
+			tokens = append(tokens, start)
+		}
+		i += n
+		col += n
+	}
+	return append(tokens, token{kind: tokenEOF, line: line, col: col}), nil
+}
+
+func isOperator(s string) bool {
+	switch s {
+	case "==", "!=", "<=", ">=", "&&", "||", "..":
+		return true
+	}
+	return false
+}
+
+// precedence of the binary operators, higher binds tighter.
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return t.kind != tokenEOF && t.kind != tokenNumber && t.text == text
+}
+
+func (p *parser) expect(text string) (token, error) {
+	t := p.next()
+	if t.kind == tokenEOF || t.text != text {
+		return t, p.errorf(t, "expected %q, got %s", text, describeToken(t))
+	}
+	return t, nil
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("%s: %s", t.pos(), fmt.Sprintf(format, args...))
+}
+
+func describeToken(t token) string {
+	if t.kind == tokenEOF {
+		return "end of file"
+	}
+	return strconv.Quote(t.text)
+}
+
+func (p *parser) parseWriteTrigger() (writeRule, error) {
+	t := p.next()
+	if !isRegisterType(t) {
+		return writeRule{}, p.errorf(t, "expected register type, got %s", describeToken(t))
+	}
+	rule := writeRule{registerType: t.text}
+	var err error
+	if rule.from, err = p.parseAddress(); err != nil {
+		return rule, err
+	}
+	rule.to = rule.from
+	if p.is("..") {
+		p.next()
+		at := p.peek()
+		if rule.to, err = p.parseAddress(); err != nil {
+			return rule, err
+		}
+		if rule.to < rule.from {
+			return rule, p.errorf(at, "end of range 0x%X is before its start 0x%X", rule.to, rule.from)
+		}
+	}
+	return rule, nil
+}
+
+func (p *parser) parseAddress() (uint16, error) {
+	t := p.next()
+	v, err := strconv.ParseUint(t.text, 0, 16)
+	if t.kind != tokenNumber || err != nil {
+		return 0, p.errorf(t, "expected address, got %s", describeToken(t))
+	}
+	return uint16(v), nil
+}
+
+func (p *parser) parseBlock() ([]statement, error) {
+	if _, err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var body []statement
+	for !p.is("}") {
+		if p.peek().kind == tokenEOF {
+			return nil, p.errorf(p.peek(), "missing }")
+		}
+		if p.is(";") {
+			p.next()
+			continue
+		}
+		s, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, s)
+	}
+	p.next()
+	return body, nil
+}
+
+func (p *parser) parseStatement() (statement, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenIdent && t.text == "if":
+		p.next()
+		cond, err := p.parseExpression(1)
+		if err != nil {
+			return nil, err
+		}
+		s := ifStatement{cond: cond}
+		if s.then, err = p.parseBlock(); err != nil {
+			return nil, err
+		}
+		if p.is("else") {
+			p.next()
+			if p.is("if") {
+				elseIf, err := p.parseStatement()
+				if err != nil {
+					return nil, err
+				}
+				s.otherwise = []statement{elseIf}
+			} else if s.otherwise, err = p.parseBlock(); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case isRegisterType(t):
+		target, err := p.parseRegister()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.parseAssignment()
+		return assignRegister{target: target, value: value}, err
+	case t.kind == tokenIdent && !isKeyword(t.text):
+		p.next()
+		value, err := p.parseAssignment()
+		return assignVariable{name: t.text, value: value}, err
+	default:
+		return nil, p.errorf(t, "expected statement, got %s", describeToken(t))
+	}
+}
+
+func (p *parser) parseAssignment() (expression, error) {
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	return p.parseExpression(1)
+}
+
+// parseRegister parses a register reference: a register type, an address and an optional datatype.
+func (p *parser) parseRegister() (registerRef, error) {
+	t := p.next()
+	ref := registerRef{pos: t.pos(), registerType: t.text, datatype: "U16"}
+	var err error
+	if ref.address, err = p.parseAddress(); err != nil {
+		return ref, err
+	}
+	if dt := p.peek(); dt.kind == tokenIdent {
+		if _, err := RegisterCount(dt.text); err == nil {
+			ref.datatype = p.next().text
+		}
+	}
+	return ref, nil
+}
+
+// parseExpression parses a sequence of operands joined by binary operators of at least precedence minPrec.
+func (p *parser) parseExpression(minPrec int) (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if t.kind != tokenPunct || !ok || prec < minPrec {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseExpression(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp{pos: t.pos(), op: t.text, l: left, r: right}
+	}
+}
+
+func (p *parser) parseUnary() (expression, error) {
+	if p.is("-") || p.is("!") {
+		op := p.next().text
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryOp{op: op, x: x}, nil
+	}
+	return p.parseOperand()
+}
+
+func (p *parser) parseOperand() (expression, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenNumber:
+		p.next()
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			var u uint64
+			if u, err = strconv.ParseUint(t.text, 0, 64); err != nil {
+				return nil, p.errorf(t, "invalid number %q", t.text)
+			}
+			v = float64(u)
+		}
+		return number(v), nil
+	case p.is("("):
+		p.next()
+		x, err := p.parseExpression(1)
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(")")
+		return x, err
+	case isRegisterType(t):
+		return p.parseRegister()
+	case t.kind == tokenIdent && !isKeyword(t.text):
+		p.next()
+		if !p.is("(") {
+			return variable(t.text), nil
+		}
+		return p.parseCall(t)
+	default:
+		return nil, p.errorf(t, "expected expression, got %s", describeToken(t))
+	}
+}
+
+func (p *parser) parseCall(name token) (expression, error) {
+	fn, ok := scriptFunctions[name.text]
+	if !ok {
+		return nil, p.errorf(name, "unknown function: %s", name.text)
+	}
+	p.next()
+	c := call{fn: fn}
+	for !p.is(")") {
+		if len(c.args) > 0 {
+			if _, err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseExpression(1)
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, arg)
+	}
+	p.next()
+	if fn.arity >= 0 && len(c.args) != fn.arity || fn.arity < 0 && len(c.args) == 0 {
+		return nil, p.errorf(name, "wrong number of arguments for %s: %d", name.text, len(c.args))
+	}
+	return c, nil
+}
+
+func isRegisterType(t token) bool {
+	switch t.text {
+	case "coil", "discrete", "input", "holding":
+		return t.kind == tokenIdent
+	}
+	return false
+}
+
+func isKeyword(s string) bool {
+	switch s {
+	case "on", "if", "else":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package modbus
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// eval evaluates expr in a write rule against mm and returns its value.
+func eval(t *testing.T, mm *MemoryMap, expr string) float64 {
+	t.Helper()
+	b, err := ParseBehaviour("on write holding 0 { result = " + expr + " }")
+	if err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+	if err := b.OnWrite(mm, "holding", 0, 1); err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+	return b.vars["result"]
+}
+
+func TestBehaviourExpressions(t *testing.T) {
+	mm := NewMemoryMap()
+	mm.PutHoldingReg(0x10, 42)
+	mm.PutCoil(3, true)
+	if err := mm.WriteValue("input", 0x20, "F32T1234", 1.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := mm.WriteValue("holding", 0x30, "S16", -7); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"7 / 2", 3.5},
+		{"7 % 3", 1},
+		{"-2 * -3", 6},
+		{"1.25 + 0x10", 17.25},
+		{"0xFFFFFFFF + 1", 4294967296},
+		{"3 > 2", 1},
+		{"3 <= 2", 0},
+		{"2 == 2 && 1 != 2", 1},
+		{"0 || 3 < 1", 0},
+		{"!0", 1},
+		{"!5", 0},
+		{"1 + 1 == 2", 1},
+		{"0 && 1 / 0", 0},
+		{"1 || 1 / 0", 1},
+		{"holding 0x10", 42},
+		{"holding 0x10 U16 + 1", 43},
+		{"input 0x20 F32T1234 * 2", 3},
+		{"holding 0x30 S16", -7},
+		{"holding 0x30", 65529},
+		{"coil 3", 1},
+		{"coil 4", 0},
+		{"discrete 3", 0},
+		{"undefined + 1", 1},
+		{"abs(-3)", 3},
+		{"min(4, 2, 8)", 2},
+		{"max(4, 2, 8)", 8},
+		{"clamp(12, 0, 10)", 10},
+		{"round(2.5)", 3},
+	}
+	for _, tt := range tests {
+		if got := eval(t, mm, tt.expr); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	if v := eval(t, mm, "random()"); v < 0 || v >= 1 {
+		t.Errorf("random() = %v, want [0, 1)", v)
+	}
+}
+
+func TestBehaviourStatements(t *testing.T) {
+	b, err := ParseBehaviour(`
+		# the measured power follows the setpoint
+		on write holding 0x100..0x101 {
+			input 0x200 F32T1234 = holding 0x100 F32T1234 * 0.5
+		}
+
+		on write coil 1 {
+			if coil 1 {
+				state = 1
+			} else if holding 0x10 > 5 {
+				state = 2
+			} else {
+				state = 3
+			}
+			holding 0x10 = state
+		}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm := NewMemoryMap()
+
+	if err := mm.WriteValue("holding", 0x100, "F32T1234", 300); err != nil {
+		t.Fatal(err)
+	}
+	// writes outside of the range don't trigger the rule
+	if err := b.OnWrite(mm, "holding", 0x102, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.OnWrite(mm, "input", 0x100, 1); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mm.ReadValue("input", 0x200, "F32T1234"); v != 0 {
+		t.Fatalf("rule triggered by unrelated write: %v", v)
+	}
+	// a write that overlaps the end of the range triggers it
+	if err := b.OnWrite(mm, "holding", 0xFF, 3); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := mm.ReadValue("input", 0x200, "F32T1234"); v != 150 {
+		t.Errorf("got input 0x200 = %v, want 150", v)
+	}
+
+	for _, tt := range []struct {
+		coil bool
+		reg  uint16
+		want uint16
+	}{
+		{true, 0, 1},
+		{false, 6, 2},
+		{false, 5, 3},
+	} {
+		mm.PutCoil(1, tt.coil)
+		mm.PutHoldingReg(0x10, tt.reg)
+		if err := b.OnWrite(mm, "coil", 1, 1); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := mm.GetHoldingReg(0x10); v != tt.want {
+			t.Errorf("coil %v, holding 0x10 = %d: got %d, want %d", tt.coil, tt.reg, v, tt.want)
+		}
+	}
+}
+
+func TestBehaviourTick(t *testing.T) {
+	b, err := ParseBehaviour("on tick 1000 { counter = counter + 1; holding 0 = counter }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm := NewMemoryMap()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		after time.Duration
+		want  uint16
+	}{
+		{0, 0},
+		{999 * time.Millisecond, 0},
+		{1000 * time.Millisecond, 1},
+		{1500 * time.Millisecond, 1},
+		{2000 * time.Millisecond, 2},
+		// a rule that fell behind runs once and restarts its interval
+		{10 * time.Second, 3},
+		{10500 * time.Millisecond, 3},
+		{11 * time.Second, 4},
+	} {
+		if err := b.Tick(mm, now.Add(tt.after)); err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := mm.GetHoldingReg(0); v != tt.want {
+			t.Errorf("after %v: got %d, want %d", tt.after, v, tt.want)
+		}
+	}
+}
+
+func TestBehaviourRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"on write holding 0 {\n  x = 1 / (holding 0 - holding 0)\n}", "2:9: division by zero"},
+		{"on write holding 0 { x = 1 % 0 }", "1:28: division by zero"},
+		{"on write holding 0 { holding 0xFFFF U32T1234 = 1 }", "1:22: "},
+		{"on write holding 0 { x = holding 0xFFFF U32T1234 }", "1:26: "},
+	}
+	for _, tt := range tests {
+		b, err := ParseBehaviour(tt.src)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.src, err)
+		}
+		mm := NewMemoryMap()
+		if err := b.OnWrite(mm, "holding", 0, 1); err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %s", tt.src, err, tt.err)
+		}
+	}
+
+	// a failing rule doesn't keep the other rules from running
+	b, err := ParseBehaviour("on write holding 0 { x = 1 / 0 }\non write holding 0 { holding 1 = 1 }")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mm := NewMemoryMap()
+	if err := b.OnWrite(mm, "holding", 0, 1); err == nil {
+		t.Error("got no error")
+	}
+	if v, _ := mm.GetHoldingReg(1); v != 1 {
+		t.Error("second rule didn't run")
+	}
+}
+
+func TestParseBehaviourErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"write holding 0 {}", `1:1: expected "on", got "write"`},
+		{"on read holding 0 {}", `1:4: expected write or tick, got "read"`},
+		{"on write table 0 {}", `1:10: expected register type, got "table"`},
+		{"on write holding x {}", `1:18: expected address, got "x"`},
+		{"on write holding 0x10000 {}", `1:18: expected address, got "0x10000"`},
+		{"on write holding 10..5 {}", "1:22: end of range 0x5 is before its start 0xA"},
+		{"on tick 0 {}", "1:9: tick interval must be a positive number of ms"},
+		{"on tick 1.5 {}", "1:9: tick interval must be a positive number of ms"},
+		{"on tick 100", "1:12: expected \"{\", got end of file"},
+		{"on tick 100 {\n  x = 1\n", "3:1: missing }"},
+		{"on tick 100 { x 1 }", `1:17: expected "=", got "1"`},
+		{"on tick 100 { 1 = x }", `1:15: expected statement, got "1"`},
+		{"on tick 100 { else = 1 }", `1:15: expected statement, got "else"`},
+		{"on tick 100 { x = }", `1:19: expected expression, got "}"`},
+		{"on tick 100 { x = (1 + 2 }", `1:26: expected ")", got "}"`},
+		{"on tick 100 { x = 1 + * 2 }", `1:23: expected expression, got "*"`},
+		{"on tick 100 { x = sqrt(2) }", "1:19: unknown function: sqrt"},
+		{"on tick 100 { x = clamp(1, 2) }", "1:19: wrong number of arguments for clamp: 2"},
+		{"on tick 100 { x = min() }", "1:19: wrong number of arguments for min: 0"},
+		{"on tick 100 { x = max(1 2) }", `1:25: expected ",", got "2"`},
+		{"on tick 100 { x = 1e }", `1:19: invalid number "1e"`},
+		{"on tick 100 { x = 1 @ 2 }", "1:21: unexpected character '@'"},
+		{"on tick 100 { if x { } else y = 1 }", `1:29: expected "{", got "y"`},
+		{"on tick 100 { holding = 1 }", `1:23: expected address, got "="`},
+	}
+	for _, tt := range tests {
+		_, err := ParseBehaviour(tt.src)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got error %v, want %s", tt.src, err, tt.err)
+		}
+	}
+}
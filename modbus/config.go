@@ -9,19 +9,41 @@ import (
 )
 
 type Serial struct {
-	Url      string  `json:"url"`
-	Timeout  int     `json:"timeout"`
-	Speed    int     `json:"speed"`
-	DataBits int     `json:"data_bits"`
-	Parity   int     `json:"parity"`
-	StopBits int     `json:"stop_bits"`
-	Slaves   []Slave `json:"slaves"`
+	Url         string `json:"url"`
+	Timeout     int    `json:"timeout"`
+	Speed       int    `json:"speed"`
+	DataBits    int    `json:"data_bits"`
+	Parity      int    `json:"parity"`
+	StopBits    int    `json:"stop_bits"`
+	MaxClients  int    `json:"max_clients,omitempty"`  // simulator only: max number of concurrent clients, 0 = unlimited
+	IdleTimeout int    `json:"idle_timeout,omitempty"` // simulator only: close idle client connections after ms, 0 = never
+	MaxReadGap  int    `json:"max_read_gap,omitempty"` // adapter only: max unused addresses between registers read together
+	// simulator only: how requests to offline slaves are answered, "silent" (default) or "exception" to reply with
+	// the gateway exceptions 0x0A (unknown slave) and 0x0B (slave offline)
+	OfflineResponse string `json:"offline_response,omitempty"`
+	// simulator only: how reads of unmapped addresses are answered, "zero" (default), "random" or "exception", with
+	// "exception" only the registers of the slave type's register.dsl and addresses written since can be read
+	Unmapped string `json:"unmapped,omitempty"`
+	// simulator only: copy values written to holding registers to the input registers at the same addresses, so that
+	// masters can read them back with function code 0x04
+	MirrorInputs bool `json:"mirror_inputs,omitempty"`
+	// tcp+tls: PEM files of the own certificate and key (the server certificate in the simulator, the client
+	// certificate in the adapter) and of the CA certificates the peer's certificate is verified against
+	TLSCert string `json:"tls_cert,omitempty"`
+	TLSKey  string `json:"tls_key,omitempty"`
+	TLSCA   string `json:"tls_ca,omitempty"`
+	// simulator only: roles of client certificates that may send write requests, all clients may write if empty
+	TLSWriteRoles []string `json:"tls_write_roles,omitempty"`
+	Slaves        []Slave  `json:"slaves"`
 }
 
 type Slave struct {
-	Address uint8  `json:"address,omitempty"`
-	Name    int    `json:"name"`
-	Type    string `json:"type"`
+	Address    uint8             `json:"address,omitempty"`
+	Name       int               `json:"name"`
+	Type       string            `json:"type"`
+	Generators []GeneratorConfig `json:"generators,omitempty"` // simulator only: value generators driving the slave's registers
+	Clocks     []ClockConfig     `json:"clocks,omitempty"`     // simulator only: clock registers of the slave
+	Faults     []FaultConfig     `json:"faults,omitempty"`     // simulator only: faults injected into the slave's responses
 }
 
 type Config struct {
@@ -0,0 +1,360 @@
+package modbus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The register.dsl file of a slave type defines the registers of the slave, one statement per line. Empty lines and
+// everything after a # outside of quotes are ignored. A statement has the grammar
+//
+//	statement    = action label address label datatype registertype { option } .
+//	action       = "read" | "write" .
+//	label        = word .                                  not interpreted, by convention "address" and "type"
+//	address      = hexdigits | "0x" hexdigits .
+//	datatype     = "BOOL" | "U16" | "S16" | "U32T1234" | "F32T3412" | "ASCII8" | "BCD16" | ...  see encoding.go
+//	registertype = "coil" | "discrete" | "input" | "holding" .
+//	option       = key "=" ( word | quoted ) .              quoted is a Go string literal, e.g. "Grid voltage"
+//
+// for example
+//
+//	read address 7E3 type F32T1234 input name="Grid voltage L1" unit=V min=0 max=300 init=231.4
+//
+// Options:
+//
+//	name         the name of the register, e.g. name="Grid voltage L1"
+//	unit         the unit of the scaled value, e.g. unit=V
+//	scale        factor of the scaled value = raw value * scale + offset, 1 by default
+//	offset       offset of the scaled value, 0 by default
+//	description  free text
+//	min, max     bounds of the scaled value, the simulator rejects writes outside with illegal data value
+//	access       ro, wo or rw (default), the simulator rejects writes to ro registers with illegal data address
+//	init         the initial scaled value of the simulated register, a number or true/false
+//	enum         labels of the raw values of a state register, e.g. enum="0:Off,1:Standby,2:Running"
+//	flags        names and severities (info, warning or error, warning by default) of the bits of a bitfield
+//	             register, e.g. flags="0:Overtemperature:error,3:Fan fault"
+//
+// BOOL is the datatype of coils and discrete inputs and only of them. enum and flags require an integer datatype,
+// ASCII registers take no numeric options. Registers of the same register type must not overlap, e.g. an F32T1234 at
+// 0x10 occupies 0x10 and 0x11.
+
+// LoadRegisterDSL reads and parses the register.dsl file at path for the slave with the given address. Parse errors
+// are prefixed with path.
+func LoadRegisterDSL(path string, slaveAddress uint8) ([]Register, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	registers, err := ParseRegisterDSL(f, slaveAddress)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return registers, nil
+}
+
+// ParseRegisterDSL parses the register definitions for the slave with the given address. Errors start with the line
+// and column of the offending field, e.g. "12:15: unknown datatype: F23T1234".
+func ParseRegisterDSL(reader io.Reader, slaveAddress uint8) ([]Register, error) {
+	var registers []Register
+	var lines []int // the line of each register
+
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		fields, err := splitFields(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%d:%w", line, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		reg, err := parseStatement(fields, slaveAddress)
+		if err != nil {
+			return nil, fmt.Errorf("%d:%w", line, err)
+		}
+		for i, other := range registers {
+			if overlaps(reg, other) {
+				return nil, fmt.Errorf("%d:%d: %s overlaps %s defined in line %d", line, fields[2].col,
+					describeRange(reg), describeRange(other), lines[i])
+			}
+		}
+		registers = append(registers, reg)
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return registers, nil
+}
+
+// dslField is a field of a statement and the 1-based column it starts at. Quoted option values are unquoted.
+type dslField struct {
+	text string
+	col  int
+}
+
+// fieldError reports an error at the field's column. The caller adds the line.
+func fieldError(f dslField, format string, args ...any) error {
+	return fmt.Errorf("%d: %s", f.col, fmt.Sprintf(format, args...))
+}
+
+// splitFields splits a line at white space and strips its comment.
+func splitFields(line string) ([]dslField, error) {
+	var fields []dslField
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == '#':
+			return fields, nil
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+			continue
+		}
+
+		f := dslField{col: i + 1}
+		start := i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' && line[i] != '\r' && line[i] != '#' {
+			if line[i] != '"' {
+				i++
+				continue
+			}
+			// a quoted value extends to the closing quote, white space and # included
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("%d: missing closing quote", i+1)
+			}
+			unquoted, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("%d: invalid quoted value %s", i+1, line[i:end+1])
+			}
+			f.text += line[start:i] + unquoted
+			i = end + 1
+			start = i
+		}
+		f.text += line[start:i]
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// parseStatement converts the fields of a statement into a register.
+func parseStatement(fields []dslField, slaveAddress uint8) (Register, error) {
+	if len(fields) < 6 {
+		end := fields[len(fields)-1]
+		return Register{}, fieldError(dslField{col: end.col + len(end.text)},
+			"incomplete statement, want: action address <address> type <datatype> <register type>")
+	}
+
+	reg := Register{SlaveAddress: slaveAddress, Action: fields[0].text, Datatype: fields[4].text, RegisterType: fields[5].text}
+	if reg.Action != "read" && reg.Action != "write" {
+		return reg, fieldError(fields[0], "statement doesn't start with 'read' or 'write': %s", reg.Action)
+	}
+
+	address, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(fields[2].text), "0x"), 16, 16)
+	if err != nil {
+		return reg, fieldError(fields[2], "invalid hex address: %s", fields[2].text)
+	}
+	reg.Address = uint16(address)
+
+	if _, err := RegisterCount(reg.Datatype); err != nil {
+		return reg, fieldError(fields[4], "%v", err)
+	}
+	switch reg.RegisterType {
+	case "coil", "discrete":
+		if reg.Datatype != "BOOL" {
+			return reg, fieldError(fields[4], "datatype of %s must be BOOL, got %s", reg.RegisterType, reg.Datatype)
+		}
+	case "input", "holding":
+		if reg.Datatype == "BOOL" {
+			return reg, fieldError(fields[4], "BOOL is only valid for coil and discrete, not for %s", reg.RegisterType)
+		}
+	default:
+		return reg, fieldError(fields[5], "unknown register type: %s", reg.RegisterType)
+	}
+
+	for _, f := range fields[6:] {
+		key, value, ok := strings.Cut(f.text, "=")
+		if !ok {
+			return reg, fieldError(f, "expected key=value, got %s", f.text)
+		}
+		if err := parseOption(&reg, key, value); err != nil {
+			return reg, fieldError(f, "%v", err)
+		}
+	}
+
+	if IsStringDatatype(reg.Datatype) && (reg.Scale != 0 || reg.Offset != 0 || reg.Min != nil || reg.Max != nil || reg.Init != nil) {
+		return reg, fieldError(fields[4], "scale, offset, min, max and init require a numeric datatype, got %s", reg.Datatype)
+	}
+	if len(reg.Enum) > 0 || len(reg.Flags) > 0 {
+		if !IsIntegerDatatype(reg.Datatype) {
+			return reg, fieldError(fields[4], "enum and flags require an integer datatype, got %s", reg.Datatype)
+		}
+		n, _ := RegisterCount(reg.Datatype)
+		for _, f := range reg.Flags {
+			if f.Bit >= 16*n {
+				return reg, fieldError(fields[4], "flag bit %d exceeds %s", f.Bit, reg.Datatype)
+			}
+		}
+	}
+	if reg.Min != nil && reg.Max != nil && *reg.Min > *reg.Max {
+		return reg, fieldError(fields[0], "min %v is greater than max %v", *reg.Min, *reg.Max)
+	}
+	if reg.Init != nil {
+		if err := reg.CheckRange(*reg.Init); err != nil {
+			return reg, fieldError(fields[0], "init: %v", err)
+		}
+	}
+	return reg, nil
+}
+
+// parseOption sets the register's field named by key to value.
+func parseOption(reg *Register, key string, value string) error {
+	number := func() (float64, error) {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %s", key, value)
+		}
+		return v, nil
+	}
+
+	var err error
+	switch key {
+	case "name":
+		reg.Name = value
+	case "unit":
+		reg.Unit = value
+	case "description":
+		reg.Description = value
+	case "scale":
+		if reg.Scale, err = number(); err == nil && reg.Scale == 0 {
+			err = fmt.Errorf("scale must not be 0")
+		}
+	case "offset":
+		reg.Offset, err = number()
+	case "min", "max":
+		var v float64
+		if v, err = number(); err == nil && key == "min" {
+			reg.Min = &v
+		} else if err == nil {
+			reg.Max = &v
+		}
+	case "access":
+		switch value {
+		case AccessReadOnly, AccessWriteOnly, AccessReadWrite:
+			reg.Access = value
+		default:
+			err = fmt.Errorf("invalid access: %s, want ro, wo or rw", value)
+		}
+	case "init":
+		var v float64
+		if v, err = parseInitialValue(value); err == nil {
+			reg.Init = &v
+		}
+	case "enum":
+		reg.Enum, err = parseEnum(value)
+	case "flags":
+		reg.Flags, err = parseFlags(value)
+	default:
+		err = fmt.Errorf("unknown option: %s", key)
+	}
+	return err
+}
+
+// parseInitialValue converts a number or a boolean into the value written to the register, true is 1.
+func parseInitialValue(s string) (float64, error) {
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return v, nil
+	}
+	if b, err := strconv.ParseBool(s); err == nil {
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if v, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return float64(v), nil
+	}
+	return 0, fmt.Errorf("invalid initial value: %s", s)
+}
+
+// parseEnum parses a comma separated list of value:label pairs.
+func parseEnum(s string) (enum []EnumValue, err error) {
+	for _, item := range strings.Split(s, ",") {
+		value, label, ok := strings.Cut(strings.TrimSpace(item), ":")
+		v, err := strconv.ParseUint(value, 0, 64)
+		if !ok || err != nil || label == "" {
+			return nil, fmt.Errorf("invalid enum value %q, want value:label", item)
+		}
+		for _, e := range enum {
+			if e.Value == v {
+				return nil, fmt.Errorf("duplicate enum value %d", v)
+			}
+		}
+		enum = append(enum, EnumValue{Value: v, Label: label})
+	}
+	return enum, nil
+}
+
+// parseFlags parses a comma separated list of bit:name[:severity] triples.
+func parseFlags(s string) (flags []Flag, err error) {
+	for _, item := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid flag %q, want bit:name[:severity]", item)
+		}
+		bit, err := strconv.Atoi(parts[0])
+		if err != nil || bit < 0 || bit > 63 {
+			return nil, fmt.Errorf("invalid flag bit %q", parts[0])
+		}
+		f := Flag{Bit: bit, Name: parts[1], Severity: SeverityWarning}
+		if len(parts) == 3 {
+			switch f.Severity = parts[2]; f.Severity {
+			case SeverityInfo, SeverityWarning, SeverityError:
+			default:
+				return nil, fmt.Errorf("invalid severity %q, want info, warning or error", parts[2])
+			}
+		}
+		for _, other := range flags {
+			if other.Bit == bit {
+				return nil, fmt.Errorf("duplicate flag bit %d", bit)
+			}
+		}
+		flags = append(flags, f)
+	}
+	return flags, nil
+}
+
+// registerRange returns the first and the last address occupied by the register.
+func registerRange(r Register) (first int, last int) {
+	n, _ := RegisterCount(r.Datatype)
+	return int(r.Address), int(r.Address) + n - 1
+}
+
+func overlaps(a Register, b Register) bool {
+	if a.RegisterType != b.RegisterType {
+		return false
+	}
+	aFirst, aLast := registerRange(a)
+	bFirst, bLast := registerRange(b)
+	return aFirst <= bLast && bFirst <= aLast
+}
+
+func describeRange(r Register) string {
+	first, last := registerRange(r)
+	if first == last {
+		return fmt.Sprintf("%s 0x%X", r.RegisterType, first)
+	}
+	return fmt.Sprintf("%s 0x%X..0x%X", r.RegisterType, first, last)
+}
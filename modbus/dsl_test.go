@@ -0,0 +1,149 @@
+package modbus
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRegisterDSL(t *testing.T) {
+	lower, upper, init := 0.0, 300.0, 231.4
+
+	tests := []struct {
+		name string
+		src  string
+		want []Register
+	}{
+		{
+			name: "minimal statement",
+			src:  "read address 7E3 type F32T1234 input",
+			want: []Register{{SlaveAddress: 5, Address: 0x7E3, Datatype: "F32T1234", RegisterType: "input", Action: "read"}},
+		},
+		{
+			name: "hex prefix, comments and empty lines",
+			src:  "# inverter\n\nwrite address 0x10 type BOOL coil # relay\n",
+			want: []Register{{SlaveAddress: 5, Address: 0x10, Datatype: "BOOL", RegisterType: "coil", Action: "write"}},
+		},
+		{
+			name: "options",
+			src: `read address 7E3 type F32T1234 input name="Grid voltage # L1" unit=V scale=0.1 offset=-5 ` +
+				`description="phase \"1\"" min=0 max=300 access=ro init=231.4`,
+			want: []Register{{
+				SlaveAddress: 5, Address: 0x7E3, Datatype: "F32T1234", RegisterType: "input", Action: "read",
+				Name: "Grid voltage # L1", Unit: "V", Scale: 0.1, Offset: -5, Description: `phase "1"`,
+				Min: &lower, Max: &upper, Access: AccessReadOnly, Init: &init,
+			}},
+		},
+		{
+			name: "enum and flags",
+			src:  "read address 1 type U16 holding enum=\"0:Off,1:Standby\"\nread address 2 type U32T1234 holding flags=\"0:Overtemp:error,17:Fan\"",
+			want: []Register{
+				{SlaveAddress: 5, Address: 1, Datatype: "U16", RegisterType: "holding", Action: "read",
+					Enum: []EnumValue{{Value: 0, Label: "Off"}, {Value: 1, Label: "Standby"}}},
+				{SlaveAddress: 5, Address: 2, Datatype: "U32T1234", RegisterType: "holding", Action: "read",
+					Flags: []Flag{{Bit: 0, Name: "Overtemp", Severity: SeverityError}, {Bit: 17, Name: "Fan", Severity: SeverityWarning}}},
+			},
+		},
+		{
+			name: "same address in different register types",
+			src:  "read address 10 type U16 holding\nread address 10 type U16 input\nread address 10 type BOOL coil",
+			want: []Register{
+				{SlaveAddress: 5, Address: 0x10, Datatype: "U16", RegisterType: "holding", Action: "read"},
+				{SlaveAddress: 5, Address: 0x10, Datatype: "U16", RegisterType: "input", Action: "read"},
+				{SlaveAddress: 5, Address: 0x10, Datatype: "BOOL", RegisterType: "coil", Action: "read"},
+			},
+		},
+		{
+			name: "adjacent registers",
+			src:  "read address 10 type F32T1234 holding\nread address 12 type U16 holding",
+			want: []Register{
+				{SlaveAddress: 5, Address: 0x10, Datatype: "F32T1234", RegisterType: "holding", Action: "read"},
+				{SlaveAddress: 5, Address: 0x12, Datatype: "U16", RegisterType: "holding", Action: "read"},
+			},
+		},
+		{
+			name: "boolean initial value",
+			src:  "write address 1 type BOOL coil init=true",
+			want: []Register{{SlaveAddress: 5, Address: 1, Datatype: "BOOL", RegisterType: "coil", Action: "write", Init: ptr(1)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRegisterDSL(strings.NewReader(tt.src), 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRegisterDSLErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"read address 10", "1:16: incomplete statement, want: action address <address> type <datatype> <register type>"},
+		{"get address 10 type U16 holding", "1:1: statement doesn't start with 'read' or 'write': get"},
+		{"read address 1G type U16 holding", "1:14: invalid hex address: 1G"},
+		{"read address 10000 type U16 holding", "1:14: invalid hex address: 10000"},
+		{"read address 10 type F23T1234 holding", "1:22: unknown datatype: F23T1234"},
+		{"read address 10 type BOOL holding", "1:22: BOOL is only valid for coil and discrete, not for holding"},
+		{"read address 10 type U16 coil", "1:22: datatype of coil must be BOOL, got U16"},
+		{"read address 10 type U16 table", "1:26: unknown register type: table"},
+		{"\n  read address 10 type U16 holding name", "2:36: expected key=value, got name"},
+		{`read address 10 type U16 holding name="Grid`, "1:39: missing closing quote"},
+		{"read address 10 type U16 holding color=red", "1:34: unknown option: color"},
+		{"read address 10 type U16 holding scale=0", "1:34: scale must not be 0"},
+		{"read address 10 type U16 holding scale=x", "1:34: invalid scale: x"},
+		{"read address 10 type U16 holding min=a", "1:34: invalid min: a"},
+		{"read address 10 type U16 holding access=rx", "1:34: invalid access: rx, want ro, wo or rw"},
+		{"read address 10 type U16 holding init=on", "1:34: invalid initial value: on"},
+		{"read address 10 type U16 holding enum=Off", `1:34: invalid enum value "Off", want value:label`},
+		{"read address 10 type U16 holding enum=0:Off,0:On", "1:34: duplicate enum value 0"},
+		{"read address 10 type U16 holding flags=0:A:fatal", `1:34: invalid severity "fatal", want info, warning or error`},
+		{"read address 10 type U16 holding flags=0:A,0:B", "1:34: duplicate flag bit 0"},
+		{"read address 10 type U16 holding flags=16:A", "1:22: flag bit 16 exceeds U16"},
+		{"read address 10 type F32T1234 holding enum=0:Off", "1:22: enum and flags require an integer datatype, got F32T1234"},
+		{"read address 10 type ASCII4 holding scale=2", "1:22: scale, offset, min, max and init require a numeric datatype, got ASCII4"},
+		{"read address 10 type U16 holding min=5 max=1", "1:1: min 5 is greater than max 1"},
+		{"read address 10 type U16 holding max=100 init=101", "1:1: init: holding 0x10: 101 out of range [-inf, 100]"},
+		{
+			"read address 10 type F32T1234 holding\nread address 11 type U16 holding",
+			"2:14: holding 0x11 overlaps holding 0x10..0x11 defined in line 1",
+		},
+		{
+			"read address 10 type U16 input\n\nread address E type U64T1234 input",
+			"3:14: input 0xE..0x11 overlaps input 0x10 defined in line 1",
+		},
+	}
+	for _, tt := range tests {
+		_, err := ParseRegisterDSL(strings.NewReader(tt.src), 1)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got error %v, want %s", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestLoadRegisterDSL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "register.dsl")
+	if err := os.WriteFile(path, []byte("read address 10 type U16 holding\nread address 10 type U16 holding\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadRegisterDSL(path, 1)
+	if want := path + ":2:14: holding 0x10 overlaps holding 0x10 defined in line 1"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %s", err, want)
+	}
+
+	if _, err := LoadRegisterDSL(filepath.Join(t.TempDir(), "missing.dsl"), 1); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want not exist", err)
+	}
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
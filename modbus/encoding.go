@@ -0,0 +1,498 @@
+package modbus
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Datatypes describe how a value is stored in registers:
+//
+//	BOOL                        coils and discrete inputs, a register holding 0 or 1
+//	U16, S16                    unsigned and signed 16-bit integers, SINT16T12 is an alias of S16
+//	U32Txxxx, S32Txxxx          unsigned and signed 32-bit integers
+//	U64Txxxx, S64Txxxx          unsigned and signed 64-bit integers, T64T1234 is an alias of U64T1234
+//	F32Txxxx, F64Txxxx          IEEE 754 floats
+//	ASCIIn                      a string of 2n characters in n registers, 2 per register, padded with NULs
+//	BCD16, BCD32                4 and 8 decimal digits, the most significant register first
+//
+// The suffix xxxx is the byte order with the bytes of a big endian value numbered from 1: 1234 is big endian (ABCD),
+// 4321 little endian (DCBA), 2143 big endian with the bytes of each register swapped (BADC) and 3412 little endian
+// registers with big endian bytes (CDAB). For 64-bit values the digits refer to the four bytes pairs a register
+// holds in the same way, e.g. 3412 stores the least significant register first.
+
+// dataKind is the kind of value a datatype stores.
+type dataKind int
+
+const (
+	kindBool dataKind = iota
+	kindUint
+	kindInt
+	kindFloat
+	kindASCII
+	kindBCD
+)
+
+// layout describes the encoding of a datatype.
+type layout struct {
+	kind  dataKind
+	words int    // number of registers
+	order string // byte order of multi register values
+}
+
+var datatypePattern = regexp.MustCompile(`^([USF])(32|64)T(1234|4321|2143|3412)$`)
+
+// parseDatatype returns the layout of datatype.
+func parseDatatype(datatype string) (layout, error) {
+	switch datatype {
+	case "BOOL":
+		return layout{kind: kindBool, words: 1}, nil
+	case "U16":
+		return layout{kind: kindUint, words: 1, order: "1234"}, nil
+	case "S16", "SINT16T12":
+		return layout{kind: kindInt, words: 1, order: "1234"}, nil
+	case "T64T1234":
+		return layout{kind: kindUint, words: 4, order: "1234"}, nil
+	case "BCD16":
+		return layout{kind: kindBCD, words: 1, order: "1234"}, nil
+	case "BCD32":
+		return layout{kind: kindBCD, words: 2, order: "1234"}, nil
+	}
+
+	if n, ok := strings.CutPrefix(datatype, "ASCII"); ok {
+		if words, err := strconv.Atoi(n); err == nil && words > 0 && words <= 125 {
+			return layout{kind: kindASCII, words: words}, nil
+		}
+	}
+	if m := datatypePattern.FindStringSubmatch(datatype); m != nil {
+		l := layout{words: 2, order: m[3]}
+		if m[2] == "64" {
+			l.words = 4
+		}
+		switch m[1] {
+		case "U":
+			l.kind = kindUint
+		case "S":
+			l.kind = kindInt
+		default:
+			l.kind = kindFloat
+		}
+		return l, nil
+	}
+	return layout{}, fmt.Errorf("unknown datatype: %s", datatype)
+}
+
+// RegisterCount returns the number of 16-bit registers occupied by a value of the given datatype.
+func RegisterCount(datatype string) (int, error) {
+	l, err := parseDatatype(datatype)
+	return l.words, err
+}
+
+// IsIntegerDatatype reports whether datatype stores an integer, which includes BCD but not BOOL.
+func IsIntegerDatatype(datatype string) bool {
+	l, err := parseDatatype(datatype)
+	return err == nil && (l.kind == kindUint || l.kind == kindInt || l.kind == kindBCD)
+}
+
+// IsStringDatatype reports whether datatype stores a string.
+func IsStringDatatype(datatype string) bool {
+	l, err := parseDatatype(datatype)
+	return err == nil && l.kind == kindASCII
+}
+
+// EncodeFloat converts v into the register representation of datatype. Integer datatypes are rounded and clamped to
+// their value range. ASCII datatypes can't be encoded from a number.
+func EncodeFloat(datatype string, v float64) ([]uint16, error) {
+	l, err := parseDatatype(datatype)
+	if err != nil {
+		return nil, err
+	}
+	switch l.kind {
+	case kindBool:
+		return EncodeValue(datatype, v != 0)
+	case kindUint, kindBCD:
+		return EncodeValue(datatype, toUint64(clamp(math.Round(v), 0, l.maxUint())))
+	case kindInt:
+		limit := math.Exp2(float64(16*l.words - 1))
+		return EncodeValue(datatype, toInt64(clamp(math.Round(v), -limit, limit-1)))
+	case kindFloat:
+		return EncodeValue(datatype, v)
+	default:
+		return nil, fmt.Errorf("%s is not numeric", datatype)
+	}
+}
+
+// DecodeFloat converts the register representation of datatype into a float64.
+func DecodeFloat(datatype string, regs []uint16) (float64, error) {
+	v, err := DecodeValue(datatype, regs)
+	if err != nil {
+		return 0, err
+	}
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return 0, fmt.Errorf("%s is not numeric", datatype)
+	default:
+		f, _ := ToFloat64(v)
+		return f, nil
+	}
+}
+
+// EncodeValue converts v into the register representation of datatype. v is a bool, an integer or float type or, for
+// ASCII datatypes, a string. Values outside of the datatype's range are rejected.
+func EncodeValue(datatype string, v any) ([]uint16, error) {
+	l, err := parseDatatype(datatype)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.kind == kindASCII {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s needs a string, got %T", datatype, v)
+		}
+		if len(s) > 2*l.words {
+			return nil, fmt.Errorf("%q exceeds the %d characters of %s", s, 2*l.words, datatype)
+		}
+		bb := make([]byte, 2*l.words)
+		copy(bb, s)
+		regs := make([]uint16, l.words)
+		for i := range regs {
+			regs[i] = uint16(bb[2*i])<<8 | uint16(bb[2*i+1])
+		}
+		return regs, nil
+	}
+	if b, ok := v.(bool); ok {
+		if l.kind != kindBool {
+			return nil, fmt.Errorf("%s needs a number, got bool", datatype)
+		}
+		if b {
+			return []uint16{1}, nil
+		}
+		return []uint16{0}, nil
+	}
+
+	f, ok := ToFloat64(v)
+	if !ok {
+		return nil, fmt.Errorf("%s needs a number, got %T", datatype, v)
+	}
+	outOfRange := fmt.Errorf("%v is out of the range of %s", v, datatype)
+
+	var raw uint64
+	switch l.kind {
+	case kindBool:
+		if f != 0 {
+			raw = 1
+		}
+	case kindUint, kindBCD:
+		u, ok := numberToUint64(v)
+		if !ok || l.words < 4 && u > uint64(l.maxUint()) {
+			return nil, outOfRange
+		}
+		raw = u
+		if l.kind == kindBCD {
+			raw = uint64BCD(u)
+		}
+	case kindInt:
+		i, ok := numberToInt64(v)
+		width := 16 * l.words
+		if !ok || width < 64 && (i < -1<<(width-1) || i > 1<<(width-1)-1) {
+			return nil, outOfRange
+		}
+		raw = uint64(i)
+	case kindFloat:
+		if l.words == 2 {
+			if !math.IsInf(f, 0) && !math.IsNaN(f) && math.Abs(f) > math.MaxFloat32 {
+				return nil, outOfRange
+			}
+			raw = uint64(math.Float32bits(float32(f)))
+		} else {
+			raw = math.Float64bits(f)
+		}
+	}
+	return reorder(splitWords(raw, l.words), l.order), nil
+}
+
+// DecodeValue converts the register representation of datatype into a bool, uint16, int16, uint32, int32, uint64,
+// int64, float32, float64 or, for ASCII datatypes, a string with trailing NULs removed.
+func DecodeValue(datatype string, regs []uint16) (any, error) {
+	l, err := parseDatatype(datatype)
+	if err != nil {
+		return nil, err
+	}
+	if len(regs) < l.words {
+		return nil, fmt.Errorf("%s needs %d registers, got %d", datatype, l.words, len(regs))
+	}
+	regs = regs[:l.words]
+
+	switch l.kind {
+	case kindBool:
+		return regs[0] != 0, nil
+	case kindASCII:
+		bb := make([]byte, 0, 2*l.words)
+		for _, r := range regs {
+			bb = append(bb, byte(r>>8), byte(r))
+		}
+		return strings.TrimRight(string(bb), "\x00"), nil
+	}
+
+	raw := joinWords(reorder(regs, l.order))
+	switch {
+	case l.kind == kindUint && l.words == 1:
+		return uint16(raw), nil
+	case l.kind == kindUint && l.words == 2:
+		return uint32(raw), nil
+	case l.kind == kindUint:
+		return raw, nil
+	case l.kind == kindInt && l.words == 1:
+		return int16(raw), nil
+	case l.kind == kindInt && l.words == 2:
+		return int32(raw), nil
+	case l.kind == kindInt:
+		return int64(raw), nil
+	case l.kind == kindFloat && l.words == 2:
+		return math.Float32frombits(uint32(raw)), nil
+	case l.kind == kindFloat:
+		return math.Float64frombits(raw), nil
+	default: // BCD
+		v := 0
+		for _, r := range regs {
+			d, err := fromBCD(r)
+			if err != nil {
+				return nil, err
+			}
+			v = v*10000 + d
+		}
+		if l.words == 1 {
+			return uint16(v), nil
+		}
+		return uint32(v), nil
+	}
+}
+
+// maxUint returns the largest value of an unsigned or BCD layout.
+func (l layout) maxUint() float64 {
+	if l.kind == kindBCD {
+		return math.Pow10(4*l.words) - 1
+	}
+	return math.Exp2(float64(16*l.words)) - 1
+}
+
+// reorder converts the big endian registers of a value into the byte order and back.
+func reorder(regs []uint16, order string) []uint16 {
+	regs = slices.Clone(regs)
+	if order == "3412" || order == "4321" {
+		slices.Reverse(regs)
+	}
+	if order == "2143" || order == "4321" {
+		for i, r := range regs {
+			regs[i] = bits.ReverseBytes16(r)
+		}
+	}
+	return regs
+}
+
+// splitWords returns the n least significant registers of v, the most significant first.
+func splitWords(v uint64, n int) []uint16 {
+	regs := make([]uint16, n)
+	for i := n - 1; i >= 0; i-- {
+		regs[i] = uint16(v)
+		v >>= 16
+	}
+	return regs
+}
+
+func joinWords(regs []uint16) (v uint64) {
+	for _, r := range regs {
+		v = v<<16 | uint64(r)
+	}
+	return
+}
+
+// uint64BCD returns the BCD representation of v, 4 digits per register.
+func uint64BCD(v uint64) (bcd uint64) {
+	for shift := 0; v > 0; shift += 16 {
+		bcd |= uint64(toBCD(int(v%10000))) << shift
+		v /= 10000
+	}
+	return
+}
+
+// ToFloat64 converts a bool, true is 1, or a number of any integer or float type to float64. It reports false for
+// other types.
+func ToFloat64(v any) (float64, bool) {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// numberToUint64 converts an integer or a float with an integer value to uint64. It reports false for negative
+// values and fractions.
+func numberToUint64(v any) (uint64, bool) {
+	switch v := v.(type) {
+	case uint64:
+		return v, true
+	case uint:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	}
+	f, ok := ToFloat64(v)
+	if !ok || f < 0 || f != math.Trunc(f) || f >= math.Exp2(64) {
+		return 0, false
+	}
+	return uint64(f), true
+}
+
+// numberToInt64 converts an integer or a float with an integer value to int64. It reports false for fractions and
+// values out of range.
+func numberToInt64(v any) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case uint64:
+		return int64(v), v <= math.MaxInt64
+	}
+	f, ok := ToFloat64(v)
+	if !ok || f != math.Trunc(f) || f < -math.Exp2(63) || f >= math.Exp2(63) {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// toUint64 converts a non-negative integral float to uint64, saturating at the maximum.
+func toUint64(f float64) uint64 {
+	if f >= math.Exp2(64) {
+		return math.MaxUint64
+	}
+	return uint64(f)
+}
+
+// toInt64 converts an integral float to int64, saturating at the limits.
+func toInt64(f float64) int64 {
+	if f >= math.Exp2(63) {
+		return math.MaxInt64
+	}
+	return int64(f)
+}
+
+// WriteValue encodes v as datatype and stores it at address of the given register type. Coils and discrete inputs
+// are set to v != 0.
+func (mm *MemoryMap) WriteValue(registerType string, address uint16, datatype string, v float64) error {
+	switch registerType {
+	case "coil":
+		return mm.WriteCoils(address, []bool{v != 0})
+	case "discrete":
+		return mm.WriteDiscreteInputs(address, []bool{v != 0})
+	}
+
+	regs, err := EncodeFloat(datatype, v)
+	if err != nil {
+		return err
+	}
+	return mm.writeRegs(registerType, address, regs)
+}
+
+// WriteString encodes s as the ASCII datatype and stores it at address of the given register type.
+func (mm *MemoryMap) WriteString(registerType string, address uint16, datatype string, s string) error {
+	regs, err := EncodeValue(datatype, s)
+	if err != nil {
+		return err
+	}
+	return mm.writeRegs(registerType, address, regs)
+}
+
+func (mm *MemoryMap) writeRegs(registerType string, address uint16, regs []uint16) error {
+	switch registerType {
+	case "input":
+		return mm.WriteInputRegs(address, regs)
+	case "holding":
+		return mm.WriteHoldingRegs(address, regs)
+	default:
+		return fmt.Errorf("unknown register type: %s", registerType)
+	}
+}
+
+// ReadValue reads the value of datatype stored at address of the given register type. Coils and discrete inputs
+// read as 0 or 1 regardless of datatype.
+func (mm *MemoryMap) ReadValue(registerType string, address uint16, datatype string) (float64, error) {
+	switch registerType {
+	case "coil", "discrete":
+		read := mm.ReadCoils
+		if registerType == "discrete" {
+			read = mm.ReadDiscreteInputs
+		}
+		bits, err := read(address, 1)
+		if err != nil || !bits[0] {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	n, err := RegisterCount(datatype)
+	if err != nil {
+		return 0, err
+	}
+	var regs []uint16
+	switch registerType {
+	case "input":
+		regs, err = mm.ReadInputRegs(address, uint16(n))
+	case "holding":
+		regs, err = mm.ReadHoldingRegs(address, uint16(n))
+	default:
+		return 0, fmt.Errorf("unknown register type: %s", registerType)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return DecodeFloat(datatype, regs)
+}
+
+func clamp(v float64, lower float64, upper float64) float64 {
+	return math.Max(lower, math.Min(upper, v))
+}
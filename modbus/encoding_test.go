@@ -0,0 +1,183 @@
+package modbus
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeValue(t *testing.T) {
+	tests := []struct {
+		datatype string
+		value    any
+		regs     []uint16
+	}{
+		{"BOOL", true, []uint16{1}},
+		{"BOOL", false, []uint16{0}},
+		{"U16", uint16(0x1234), []uint16{0x1234}},
+		{"S16", int16(-2), []uint16{0xFFFE}},
+		{"SINT16T12", int16(-32768), []uint16{0x8000}},
+
+		{"U32T1234", uint32(0x11223344), []uint16{0x1122, 0x3344}},
+		{"U32T4321", uint32(0x11223344), []uint16{0x4433, 0x2211}},
+		{"U32T2143", uint32(0x11223344), []uint16{0x2211, 0x4433}},
+		{"U32T3412", uint32(0x11223344), []uint16{0x3344, 0x1122}},
+		{"S32T1234", int32(-2), []uint16{0xFFFF, 0xFFFE}},
+		{"S32T4321", int32(-2), []uint16{0xFEFF, 0xFFFF}},
+		{"S32T2143", int32(-2), []uint16{0xFFFF, 0xFEFF}},
+		{"S32T3412", int32(-2), []uint16{0xFFFE, 0xFFFF}},
+
+		{"U64T1234", uint64(0x1122334455667788), []uint16{0x1122, 0x3344, 0x5566, 0x7788}},
+		{"U64T4321", uint64(0x1122334455667788), []uint16{0x8877, 0x6655, 0x4433, 0x2211}},
+		{"U64T2143", uint64(0x1122334455667788), []uint16{0x2211, 0x4433, 0x6655, 0x8877}},
+		{"U64T3412", uint64(0x1122334455667788), []uint16{0x7788, 0x5566, 0x3344, 0x1122}},
+		{"T64T1234", uint64(16), []uint16{0, 0, 0, 16}},
+		{"S64T1234", int64(math.MinInt64), []uint16{0x8000, 0, 0, 0}},
+		{"S64T3412", int64(-2), []uint16{0xFFFE, 0xFFFF, 0xFFFF, 0xFFFF}},
+
+		{"F32T1234", float32(1.5), []uint16{0x3FC0, 0x0000}},
+		{"F32T4321", float32(1.5), []uint16{0x0000, 0xC03F}},
+		{"F32T2143", float32(1.5), []uint16{0xC03F, 0x0000}},
+		{"F32T3412", float32(1.5), []uint16{0x0000, 0x3FC0}},
+		{"F64T1234", float64(1.5), []uint16{0x3FF8, 0, 0, 0}},
+		{"F64T3412", float64(-2), []uint16{0, 0, 0, 0xC000}},
+
+		{"BCD16", uint16(1234), []uint16{0x1234}},
+		{"BCD16", uint16(9999), []uint16{0x9999}},
+		{"BCD32", uint32(12345678), []uint16{0x1234, 0x5678}},
+		{"BCD32", uint32(1), []uint16{0x0000, 0x0001}},
+
+		{"ASCII1", "OK", []uint16{0x4F4B}},
+		{"ASCII3", "Hello", []uint16{0x4865, 0x6C6C, 0x6F00}},
+		{"ASCII2", "", []uint16{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.datatype, func(t *testing.T) {
+			regs, err := EncodeValue(tt.datatype, tt.value)
+			if err != nil {
+				t.Fatalf("EncodeValue(%v): %v", tt.value, err)
+			}
+			if !slices.Equal(regs, tt.regs) {
+				t.Errorf("EncodeValue(%v) = %04X, want %04X", tt.value, regs, tt.regs)
+			}
+
+			n, err := RegisterCount(tt.datatype)
+			if err != nil || n != len(tt.regs) {
+				t.Errorf("RegisterCount() = %d, %v, want %d", n, err, len(tt.regs))
+			}
+
+			v, err := DecodeValue(tt.datatype, tt.regs)
+			if err != nil {
+				t.Fatalf("DecodeValue(%04X): %v", tt.regs, err)
+			}
+			if v != tt.value {
+				t.Errorf("DecodeValue(%04X) = %v (%T), want %v (%T)", tt.regs, v, v, tt.value, tt.value)
+			}
+		})
+	}
+}
+
+func TestEncodeValueErrors(t *testing.T) {
+	tests := []struct {
+		datatype string
+		value    any
+		err      string
+	}{
+		{"U16", 65536, "out of the range"},
+		{"U16", -1, "out of the range"},
+		{"U16", 1.5, "out of the range"},
+		{"S16", 32768, "out of the range"},
+		{"S16", -32769, "out of the range"},
+		{"U32T1234", uint64(1) << 32, "out of the range"},
+		{"S32T3412", int64(math.MinInt32) - 1, "out of the range"},
+		{"S64T1234", uint64(math.MaxUint64), "out of the range"},
+		{"F32T1234", 1e40, "out of the range"},
+		{"BCD16", 10000, "out of the range"},
+		{"BCD16", -1, "out of the range"},
+		{"BCD32", 100000000, "out of the range"},
+		{"ASCII2", "Hello", "exceeds the 4 characters"},
+		{"ASCII2", 1, "needs a string"},
+		{"U16", "1", "needs a number"},
+		{"U16", true, "needs a number"},
+		{"X16", 1, "unknown datatype"},
+		{"U32T1243", 1, "unknown datatype"},
+		{"ASCII0", "", "unknown datatype"},
+		{"ASCII126", "", "unknown datatype"},
+	}
+	for _, tt := range tests {
+		_, err := EncodeValue(tt.datatype, tt.value)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("EncodeValue(%s, %v) = %v, want error containing %q", tt.datatype, tt.value, err, tt.err)
+		}
+	}
+}
+
+func TestDecodeValueErrors(t *testing.T) {
+	tests := []struct {
+		datatype string
+		regs     []uint16
+		err      string
+	}{
+		{"U32T1234", []uint16{1}, "needs 2 registers, got 1"},
+		{"ASCII4", []uint16{0x4142}, "needs 4 registers, got 1"},
+		{"BCD16", []uint16{0x12A4}, "BCD"},
+		{"BCD32", []uint16{0x1234, 0xF000}, "BCD"},
+	}
+	for _, tt := range tests {
+		_, err := DecodeValue(tt.datatype, tt.regs)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("DecodeValue(%s, %04X) = %v, want error containing %q", tt.datatype, tt.regs, err, tt.err)
+		}
+	}
+}
+
+func TestEncodeFloat(t *testing.T) {
+	tests := []struct {
+		datatype string
+		value    float64
+		regs     []uint16
+	}{
+		{"U16", 70000, []uint16{0xFFFF}},
+		{"U16", -5, []uint16{0}},
+		{"U16", 1.6, []uint16{2}},
+		{"S16", -1e9, []uint16{0x8000}},
+		{"S32T3412", -2, []uint16{0xFFFE, 0xFFFF}},
+		{"U64T1234", 1e30, []uint16{0xFFFF, 0xFFFF, 0xFFFF, 0xFFFF}},
+		{"BCD16", 12345, []uint16{0x9999}},
+		{"F32T3412", 1.5, []uint16{0x0000, 0x3FC0}},
+		{"BOOL", 2, []uint16{1}},
+	}
+	for _, tt := range tests {
+		regs, err := EncodeFloat(tt.datatype, tt.value)
+		if err != nil || !slices.Equal(regs, tt.regs) {
+			t.Errorf("EncodeFloat(%s, %v) = %04X, %v, want %04X", tt.datatype, tt.value, regs, err, tt.regs)
+		}
+		if _, err := DecodeFloat(tt.datatype, tt.regs); err != nil {
+			t.Errorf("DecodeFloat(%s, %04X): %v", tt.datatype, tt.regs, err)
+		}
+	}
+
+	if _, err := EncodeFloat("ASCII2", 1); err == nil {
+		t.Error("EncodeFloat(ASCII2) succeeded")
+	}
+	if _, err := DecodeFloat("ASCII2", []uint16{0x4142, 0}); err == nil {
+		t.Error("DecodeFloat(ASCII2) succeeded")
+	}
+}
+
+func TestDatatypeKinds(t *testing.T) {
+	for _, datatype := range []string{"U16", "S16", "U32T3412", "S64T4321", "T64T1234", "BCD16", "BCD32"} {
+		if !IsIntegerDatatype(datatype) {
+			t.Errorf("IsIntegerDatatype(%s) = false", datatype)
+		}
+	}
+	for _, datatype := range []string{"BOOL", "F32T1234", "F64T1234", "ASCII2", "X"} {
+		if IsIntegerDatatype(datatype) {
+			t.Errorf("IsIntegerDatatype(%s) = true", datatype)
+		}
+	}
+	if !IsStringDatatype("ASCII10") || IsStringDatatype("U16") {
+		t.Error("IsStringDatatype")
+	}
+}
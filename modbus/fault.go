@@ -0,0 +1,158 @@
+package modbus
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault types
+const (
+	FaultDelay       = "delay"         // delays the response by delay ms plus a random jitter of up to jitter ms
+	FaultDrop        = "drop"          // sends no response at all
+	FaultWrongTxnId  = "wrong_txn_id"  // answers with a transaction id other than the request's (MBAP framing only)
+	FaultTruncate    = "truncate"      // cuts off the end of the response frame
+	FaultCorrupt     = "corrupt"       // flips a random bit of the response payload
+	FaultWrongUnitId = "wrong_unit_id" // answers with a unit id other than the request's
+	FaultException   = "exception"     // answers with exception instead of executing the request
+	FaultClose       = "close"         // sends the first half of the response frame, then closes the connection
+)
+
+// FaultConfig describes a fault injected into the responses of a slave. A fault fires on requests with one of its
+// function codes, or on all requests if there are none. When it fires is controlled by a schedule, a probability or
+// both:
+//
+//	every            fires on every nth request
+//	period, active   fires during the first active ms of every period ms
+//	probability      fires with the given probability (0..1) when the schedule allows it, always if 0
+type FaultConfig struct {
+	Type          string  `json:"type"`
+	FunctionCodes []int   `json:"function_codes,omitempty"`
+	Probability   float64 `json:"probability,omitempty"`
+	Every         int     `json:"every,omitempty"`
+	Period        int     `json:"period,omitempty"`    // ms
+	Active        int     `json:"active,omitempty"`    // ms
+	Delay         int     `json:"delay,omitempty"`     // ms, delay only
+	Jitter        int     `json:"jitter,omitempty"`    // ms, delay only
+	Exception     uint8   `json:"exception,omitempty"` // exception only: the exception code, 0x04 by default
+	Disabled      bool    `json:"disabled,omitempty"`  // the fault starts switched off
+}
+
+// Fault decides which requests a configured fault fires on. A Fault can be switched on and off at runtime and is
+// safe for concurrent use.
+type Fault struct {
+	config  FaultConfig
+	lock    sync.Mutex
+	enabled bool
+	started time.Time
+	count   int // requests matching the function codes so far
+}
+
+// NewFault creates the fault described by config.
+func NewFault(config FaultConfig) (*Fault, error) {
+	switch config.Type {
+	case FaultDelay:
+		if config.Delay < 0 || config.Jitter < 0 {
+			return nil, fmt.Errorf("delay fault: delay and jitter must be >= 0")
+		}
+	case FaultException:
+		if config.Exception == 0 {
+			config.Exception = 0x04
+		}
+	case FaultDrop, FaultWrongTxnId, FaultTruncate, FaultCorrupt, FaultWrongUnitId, FaultClose:
+	default:
+		return nil, fmt.Errorf("unknown fault type: %s", config.Type)
+	}
+	if config.Probability < 0 || config.Probability > 1 {
+		return nil, fmt.Errorf("%s fault: probability must be within 0..1", config.Type)
+	}
+	if config.Period < 0 || config.Active < 0 || config.Active > config.Period {
+		return nil, fmt.Errorf("%s fault: active must be within 0..period", config.Type)
+	}
+
+	return &Fault{config: config, enabled: !config.Disabled, started: time.Now()}, nil
+}
+
+// Config returns the configuration of the fault.
+func (f *Fault) Config() FaultConfig {
+	return f.config
+}
+
+// Enabled reports whether the fault is switched on.
+func (f *Fault) Enabled() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.enabled
+}
+
+// SetEnabled switches the fault on or off.
+func (f *Fault) SetEnabled(enabled bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.enabled = enabled
+}
+
+// Fires reports whether the fault fires on a request with the given function code received at now.
+func (f *Fault) Fires(functionCode uint8, now time.Time) bool {
+	if len(f.config.FunctionCodes) > 0 && !slices.Contains(f.config.FunctionCodes, int(functionCode)) {
+		return false
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.count++
+	if !f.enabled {
+		return false
+	}
+	if f.config.Every > 0 && f.count%f.config.Every != 0 {
+		return false
+	}
+	if f.config.Period > 0 {
+		period := time.Duration(f.config.Period) * time.Millisecond
+		if now.Sub(f.started)%period >= time.Duration(f.config.Active)*time.Millisecond {
+			return false
+		}
+	}
+	return f.config.Probability == 0 || rand.Float64() < f.config.Probability
+}
+
+// Delay returns the response delay of a delay fault: the configured delay plus a random jitter.
+func (f *Fault) Delay() time.Duration {
+	d := time.Duration(f.config.Delay) * time.Millisecond
+	if f.config.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(f.config.Jitter) * int64(time.Millisecond)))
+	}
+	return d
+}
+
+// String returns a short description of the fault, e.g. "delay 100±50ms fc 3,4 p=0.5".
+func (f *Fault) String() string {
+	var sb strings.Builder
+	sb.WriteString(f.config.Type)
+	switch f.config.Type {
+	case FaultDelay:
+		fmt.Fprintf(&sb, " %d±%dms", f.config.Delay, f.config.Jitter)
+	case FaultException:
+		fmt.Fprintf(&sb, " %02X", f.config.Exception)
+	}
+	if len(f.config.FunctionCodes) > 0 {
+		codes := make([]string, len(f.config.FunctionCodes))
+		for i, fc := range f.config.FunctionCodes {
+			codes[i] = fmt.Sprintf("%d", fc)
+		}
+		fmt.Fprintf(&sb, " fc %s", strings.Join(codes, ","))
+	}
+	if f.config.Every > 0 {
+		fmt.Fprintf(&sb, " every %d", f.config.Every)
+	}
+	if f.config.Period > 0 {
+		fmt.Fprintf(&sb, " %d/%dms", f.config.Active, f.config.Period)
+	}
+	if f.config.Probability > 0 {
+		fmt.Fprintf(&sb, " p=%g", f.config.Probability)
+	}
+	return sb.String()
+}
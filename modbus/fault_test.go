@@ -0,0 +1,68 @@
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewFault(t *testing.T) {
+	for _, config := range []FaultConfig{
+		{Type: "flaky"},
+		{Type: FaultDelay, Delay: -1},
+		{Type: FaultDrop, Probability: 1.5},
+		{Type: FaultDrop, Period: 100, Active: 200},
+	} {
+		if _, err := NewFault(config); err == nil {
+			t.Errorf("%+v accepted", config)
+		}
+	}
+
+	f, err := NewFault(FaultConfig{Type: FaultException, Disabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Config().Exception != 0x04 || f.Enabled() {
+		t.Errorf("got %+v, enabled %v, want exception 04 switched off", f.Config(), f.Enabled())
+	}
+}
+
+func TestFaultFires(t *testing.T) {
+	f, _ := NewFault(FaultConfig{Type: FaultDrop, FunctionCodes: []int{3, 4}, Every: 3})
+	now := time.Now()
+	var fired []bool
+	for _, fc := range []uint8{3, 6, 4, 3, 3, 4, 3} {
+		fired = append(fired, f.Fires(fc, now))
+	}
+	want := []bool{false, false, false, true, false, false, true}
+	for i := range want {
+		if fired[i] != want[i] {
+			t.Fatalf("got %v, want %v", fired, want)
+		}
+	}
+
+	// active during the first 100 ms of every second
+	f, _ = NewFault(FaultConfig{Type: FaultDrop, Period: 1000, Active: 100})
+	for offset, want := range map[time.Duration]bool{50 * time.Millisecond: true, 500 * time.Millisecond: false, 1050 * time.Millisecond: true} {
+		if got := f.Fires(3, f.started.Add(offset)); got != want {
+			t.Errorf("%v: got %v, want %v", offset, got, want)
+		}
+	}
+
+	f, _ = NewFault(FaultConfig{Type: FaultDrop, Probability: 0.5})
+	var n int
+	for range 1000 {
+		if f.Fires(3, now) {
+			n++
+		}
+	}
+	if n < 350 || n > 650 {
+		t.Errorf("fired %d of 1000 times with p=0.5", n)
+	}
+}
+
+func TestFaultString(t *testing.T) {
+	f, _ := NewFault(FaultConfig{Type: FaultDelay, Delay: 100, Jitter: 50, FunctionCodes: []int{3, 4}, Probability: 0.5})
+	if got, want := f.String(), "delay 100±50ms fc 3,4 p=0.5"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,229 @@
+package modbus
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GeneratorConfig binds a value generator to an address of a simulated slave. Which of the parameters are used
+// depends on the generator type:
+//
+//	constant    value
+//	ramp        min, max, period: rises linearly from min to max within period, then starts over
+//	sine        offset, amplitude, period
+//	square      min, max, period, duty: max for the duty fraction of period, min otherwise
+//	randomwalk  value (start), step, min, max: moves up to step per update, bounded by min and max
+//	steps       values, period: holds each of values for period, then starts over
+//	csv         file, column, period: replays the numeric rows of column, one row per period, then starts over
+type GeneratorConfig struct {
+	Type         string    `json:"type"`
+	RegisterType string    `json:"register_type"`      // coil | discrete | input | holding
+	Address      uint16    `json:"address"`            // first register of the encoded value
+	Datatype     string    `json:"datatype,omitempty"` // encoding of register values, defaults to U16
+	Interval     int       `json:"interval,omitempty"` // update interval in ms, defaults to 1000
+	Value        float64   `json:"value,omitempty"`
+	Min          float64   `json:"min,omitempty"`
+	Max          float64   `json:"max,omitempty"`
+	Period       int       `json:"period,omitempty"` // ms
+	Amplitude    float64   `json:"amplitude,omitempty"`
+	Offset       float64   `json:"offset,omitempty"`
+	Duty         float64   `json:"duty,omitempty"`
+	Step         float64   `json:"step,omitempty"`
+	Values       []float64 `json:"values,omitempty"`
+	File         string    `json:"file,omitempty"`
+	Column       int       `json:"column,omitempty"`
+}
+
+// UpdateInterval returns the configured update interval, one second by default.
+func (c GeneratorConfig) UpdateInterval() time.Duration {
+	if c.Interval <= 0 {
+		return time.Second
+	}
+	return time.Duration(c.Interval) * time.Millisecond
+}
+
+// EffectiveDatatype returns the configured datatype, U16 by default.
+func (c GeneratorConfig) EffectiveDatatype() string {
+	if c.Datatype == "" {
+		return "U16"
+	}
+	return c.Datatype
+}
+
+// Generator produces the value of a simulated register over time.
+type Generator interface {
+	// Value returns the value at elapsed time since the generator has been started.
+	Value(elapsed time.Duration) float64
+}
+
+// NewGenerator creates the generator described by config.
+func NewGenerator(config GeneratorConfig) (Generator, error) {
+	switch config.RegisterType {
+	case "coil", "discrete":
+	case "input", "holding":
+		if _, err := RegisterCount(config.EffectiveDatatype()); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown register type: %s", config.RegisterType)
+	}
+
+	period := time.Duration(config.Period) * time.Millisecond
+	needsPeriod := func() error {
+		if period <= 0 {
+			return fmt.Errorf("%s generator: period must be > 0", config.Type)
+		}
+		return nil
+	}
+
+	switch config.Type {
+	case "constant":
+		return constant(config.Value), nil
+	case "ramp":
+		if err := needsPeriod(); err != nil {
+			return nil, err
+		}
+		return ramp{min: config.Min, max: config.Max, period: period}, nil
+	case "sine":
+		if err := needsPeriod(); err != nil {
+			return nil, err
+		}
+		return sine{offset: config.Offset, amplitude: config.Amplitude, period: period}, nil
+	case "square":
+		if err := needsPeriod(); err != nil {
+			return nil, err
+		}
+		duty := config.Duty
+		if duty <= 0 || duty >= 1 {
+			duty = 0.5
+		}
+		return square{min: config.Min, max: config.Max, period: period, duty: duty}, nil
+	case "randomwalk":
+		if config.Min > config.Max {
+			return nil, fmt.Errorf("randomwalk generator: min %v > max %v", config.Min, config.Max)
+		}
+		return &randomWalk{value: clamp(config.Value, config.Min, config.Max), step: config.Step, min: config.Min, max: config.Max}, nil
+	case "steps":
+		if err := needsPeriod(); err != nil {
+			return nil, err
+		}
+		if len(config.Values) == 0 {
+			return nil, fmt.Errorf("steps generator: no values")
+		}
+		return steps{values: config.Values, period: period}, nil
+	case "csv":
+		if err := needsPeriod(); err != nil {
+			return nil, err
+		}
+		if config.Column < 0 {
+			return nil, fmt.Errorf("csv generator: column must be >= 0, got %d", config.Column)
+		}
+		values, err := readCSVColumn(config.File, config.Column)
+		if err != nil {
+			return nil, fmt.Errorf("csv generator: %w", err)
+		}
+		return steps{values: values, period: period}, nil
+	default:
+		return nil, fmt.Errorf("unknown generator type: %s", config.Type)
+	}
+}
+
+type constant float64
+
+func (c constant) Value(time.Duration) float64 {
+	return float64(c)
+}
+
+type ramp struct {
+	min, max float64
+	period   time.Duration
+}
+
+func (r ramp) Value(elapsed time.Duration) float64 {
+	fraction := float64(elapsed%r.period) / float64(r.period)
+	return r.min + (r.max-r.min)*fraction
+}
+
+type sine struct {
+	offset, amplitude float64
+	period            time.Duration
+}
+
+func (s sine) Value(elapsed time.Duration) float64 {
+	return s.offset + s.amplitude*math.Sin(2*math.Pi*float64(elapsed)/float64(s.period))
+}
+
+type square struct {
+	min, max float64
+	period   time.Duration
+	duty     float64
+}
+
+func (s square) Value(elapsed time.Duration) float64 {
+	if float64(elapsed%s.period) < s.duty*float64(s.period) {
+		return s.max
+	}
+	return s.min
+}
+
+type randomWalk struct {
+	lock                  sync.Mutex
+	value, step, min, max float64
+}
+
+func (r *randomWalk) Value(time.Duration) float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.value = clamp(r.value+(rand.Float64()*2-1)*r.step, r.min, r.max)
+	return r.value
+}
+
+type steps struct {
+	values []float64
+	period time.Duration
+}
+
+func (s steps) Value(elapsed time.Duration) float64 {
+	return s.values[int(elapsed/s.period)%len(s.values)]
+}
+
+// readCSVColumn returns the numeric values of the given zero based column. Rows whose column isn't numeric, such as
+// a header, are skipped.
+func readCSVColumn(name string, column int) ([]float64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.Comment = '#'
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var values []float64
+	for _, record := range records {
+		if column >= len(record) {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(record[column]), 64)
+		if err != nil {
+			continue
+		}
+		values = append(values, v)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s: no numeric values in column %d", name, column)
+	}
+	return values, nil
+}
@@ -1,44 +1,266 @@
 package modbus
 
-// MemoryMap represents a memory map for Modbus communication.
+import (
+	"errors"
+	"math/rand"
+	"sync"
+)
+
+// addressSpace is the number of addresses of each of the four modbus data tables.
+const addressSpace = 0x10000
+
+// UnmappedPolicy defines how reads of addresses that have never been written are answered.
+type UnmappedPolicy string
+
+const (
+	UnmappedZero      UnmappedPolicy = "zero"      // unmapped addresses read as 0 / false
+	UnmappedRandom    UnmappedPolicy = "random"    // unmapped addresses read as random values
+	UnmappedException UnmappedPolicy = "exception" // reads touching an unmapped address fail with ErrUnmappedAddress
+)
+
+var (
+	// ErrUnmappedAddress is returned by reads touching an unmapped address if the policy is UnmappedException.
+	ErrUnmappedAddress = errors.New("unmapped address")
+	// ErrAddressOutOfRange is returned if an address range exceeds the 16-bit address space.
+	ErrAddressOutOfRange = errors.New("address range exceeds address space")
+)
+
+// ParseUnmappedPolicy converts the policy's config representation into an UnmappedPolicy. An empty string selects
+// UnmappedZero.
+func ParseUnmappedPolicy(s string) (UnmappedPolicy, error) {
+	switch p := UnmappedPolicy(s); p {
+	case "":
+		return UnmappedZero, nil
+	case UnmappedZero, UnmappedRandom, UnmappedException:
+		return p, nil
+	default:
+		return "", errors.New("unknown unmapped policy: " + s)
+	}
+}
+
+// area is one data table covering the whole 16-bit address space. mapped tracks which addresses have been written.
+type area[T bool | uint16] struct {
+	values [addressSpace]T
+	mapped [addressSpace / 64]uint64
+}
+
+func (a *area[T]) isMapped(address uint16) bool {
+	return a.mapped[address/64]&(1<<(address%64)) != 0
+}
+
+func (a *area[T]) put(address uint16, value T) {
+	a.values[address] = value
+	a.mapped[address/64] |= 1 << (address % 64)
+}
+
+func (a *area[T]) get(address uint16) (T, bool) {
+	return a.values[address], a.isMapped(address)
+}
+
+// read copies quantity values starting at address. Unmapped addresses are filled according to policy, random
+// creates the random value of a single address.
+func (a *area[T]) read(address uint16, quantity uint16, policy UnmappedPolicy, random func() T) ([]T, error) {
+	if err := checkRange(address, int(quantity)); err != nil {
+		return nil, err
+	}
+
+	values := make([]T, quantity)
+	for i := range values {
+		addr := address + uint16(i)
+		if a.isMapped(addr) {
+			values[i] = a.values[addr]
+			continue
+		}
+		switch policy {
+		case UnmappedException:
+			return nil, ErrUnmappedAddress
+		case UnmappedRandom:
+			values[i] = random()
+		}
+	}
+	return values, nil
+}
+
+func (a *area[T]) write(address uint16, values []T) error {
+	if err := checkRange(address, len(values)); err != nil {
+		return err
+	}
+
+	for i, v := range values {
+		a.put(address+uint16(i), v)
+	}
+	return nil
+}
+
+func checkRange(address uint16, quantity int) error {
+	if int(address)+quantity > addressSpace {
+		return ErrAddressOutOfRange
+	}
+	return nil
+}
+
+func randomBool() bool {
+	return rand.Intn(2) == 1
+}
+
+func randomUint16() uint16 {
+	return uint16(rand.Intn(addressSpace))
+}
+
+// MemoryMap represents a memory map for Modbus communication. Each of the four data tables is stored contiguously
+// over the whole address space. A MemoryMap is safe for concurrent use, range reads return a consistent snapshot.
 type MemoryMap struct {
-	coils          map[uint16]bool
-	discreteInputs map[uint16]bool
-	inputRegs      map[uint16]uint16
-	holdingRegs    map[uint16]uint16
+	lock           sync.RWMutex
+	policy         UnmappedPolicy
+	coils          area[bool]
+	discreteInputs area[bool]
+	inputRegs      area[uint16]
+	holdingRegs    area[uint16]
 }
 
-// NewMemoryMap creates a new MemoryMap instance.
+// NewMemoryMap creates a new MemoryMap instance. Unmapped addresses read as zero until another policy is set with
+// SetUnmappedPolicy.
 func NewMemoryMap() *MemoryMap {
-	return &MemoryMap{
-		coils:          make(map[uint16]bool),
-		discreteInputs: make(map[uint16]bool),
-		inputRegs:      make(map[uint16]uint16),
-		holdingRegs:    make(map[uint16]uint16),
-	}
+	return &MemoryMap{policy: UnmappedZero}
+}
+
+// SetUnmappedPolicy sets the policy for reads of unmapped addresses.
+func (mm *MemoryMap) SetUnmappedPolicy(policy UnmappedPolicy) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	mm.policy = policy
+}
+
+// UnmappedPolicy returns the policy for reads of unmapped addresses.
+func (mm *MemoryMap) UnmappedPolicy() UnmappedPolicy {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.policy
 }
 
 // PutCoil sets the value of a coil in the memory map.
 func (mm *MemoryMap) PutCoil(address uint16, value bool) {
-	mm.coils[address] = value
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	mm.coils.put(address, value)
+}
+
+// GetCoil returns the value of a coil and whether the coil is mapped.
+func (mm *MemoryMap) GetCoil(address uint16) (bool, bool) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.coils.get(address)
+}
+
+// ReadCoils returns quantity coils starting at address.
+func (mm *MemoryMap) ReadCoils(address uint16, quantity uint16) ([]bool, error) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.coils.read(address, quantity, mm.policy, randomBool)
+}
+
+// WriteCoils sets the coils starting at address to values.
+func (mm *MemoryMap) WriteCoils(address uint16, values []bool) error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	return mm.coils.write(address, values)
 }
 
 // PutDiscreteInput sets the value of a discrete input in the memory map.
 func (mm *MemoryMap) PutDiscreteInput(address uint16, value bool) {
-	mm.discreteInputs[address] = value
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	mm.discreteInputs.put(address, value)
+}
+
+// GetDiscreteInput returns the value of a discrete input and whether the discrete input is mapped.
+func (mm *MemoryMap) GetDiscreteInput(address uint16) (bool, bool) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.discreteInputs.get(address)
+}
+
+// ReadDiscreteInputs returns quantity discrete inputs starting at address.
+func (mm *MemoryMap) ReadDiscreteInputs(address uint16, quantity uint16) ([]bool, error) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.discreteInputs.read(address, quantity, mm.policy, randomBool)
+}
+
+// WriteDiscreteInputs sets the discrete inputs starting at address to values.
+func (mm *MemoryMap) WriteDiscreteInputs(address uint16, values []bool) error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	return mm.discreteInputs.write(address, values)
 }
 
 // PutInputReg sets the value of an input register in the memory map.
 func (mm *MemoryMap) PutInputReg(address uint16, value uint16) {
-	mm.inputRegs[address] = value
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	mm.inputRegs.put(address, value)
+}
+
+// GetInputReg returns the value of an input register and whether the register is mapped.
+func (mm *MemoryMap) GetInputReg(address uint16) (uint16, bool) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.inputRegs.get(address)
 }
 
-func (mm MemoryMap) GetInputReg(address uint16) (uint16, bool) {
-	value, ok := mm.inputRegs[address]
-	return value, ok
+// ReadInputRegs returns quantity input registers starting at address.
+func (mm *MemoryMap) ReadInputRegs(address uint16, quantity uint16) ([]uint16, error) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.inputRegs.read(address, quantity, mm.policy, randomUint16)
+}
+
+// WriteInputRegs sets the input registers starting at address to values.
+func (mm *MemoryMap) WriteInputRegs(address uint16, values []uint16) error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	return mm.inputRegs.write(address, values)
 }
 
 // PutHoldingReg sets the value of a holding register in the memory map.
 func (mm *MemoryMap) PutHoldingReg(address uint16, value uint16) {
-	mm.holdingRegs[address] = value
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	mm.holdingRegs.put(address, value)
+}
+
+// GetHoldingReg returns the value of a holding register and whether the register is mapped.
+func (mm *MemoryMap) GetHoldingReg(address uint16) (uint16, bool) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.holdingRegs.get(address)
+}
+
+// ReadHoldingRegs returns quantity holding registers starting at address.
+func (mm *MemoryMap) ReadHoldingRegs(address uint16, quantity uint16) ([]uint16, error) {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return mm.holdingRegs.read(address, quantity, mm.policy, randomUint16)
+}
+
+// WriteHoldingRegs sets the holding registers starting at address to values.
+func (mm *MemoryMap) WriteHoldingRegs(address uint16, values []uint16) error {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	return mm.holdingRegs.write(address, values)
+}
+
+// MaskWriteHoldingReg atomically sets the holding register at address to (current AND andMask) OR (orMask AND NOT
+// andMask) and returns the new value.
+func (mm *MemoryMap) MaskWriteHoldingReg(address uint16, andMask uint16, orMask uint16) (uint16, error) {
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+
+	current, err := mm.holdingRegs.read(address, 1, mm.policy, randomUint16)
+	if err != nil {
+		return 0, err
+	}
+	value := (current[0] & andMask) | (orMask &^ andMask)
+	mm.holdingRegs.put(address, value)
+	return value, nil
 }
@@ -0,0 +1,116 @@
+package modbus
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestMemoryMapRanges(t *testing.T) {
+	mm := NewMemoryMap()
+	if err := mm.WriteHoldingRegs(0xFFFE, []uint16{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if regs, err := mm.ReadHoldingRegs(0xFFFD, 3); err != nil || !slices.Equal(regs, []uint16{0, 1, 2}) {
+		t.Errorf("got %v, %v, want [0 1 2]", regs, err)
+	}
+	if err := mm.WriteHoldingRegs(0xFFFF, []uint16{1, 2}); !errors.Is(err, ErrAddressOutOfRange) {
+		t.Errorf("write beyond 0xFFFF: got %v", err)
+	}
+	if _, err := mm.ReadInputRegs(0xFFFF, 2); !errors.Is(err, ErrAddressOutOfRange) {
+		t.Errorf("read beyond 0xFFFF: got %v", err)
+	}
+	if err := mm.WriteCoils(0xFFFF, []bool{true, true}); !errors.Is(err, ErrAddressOutOfRange) {
+		t.Errorf("coil write beyond 0xFFFF: got %v", err)
+	}
+	if v, ok := mm.GetHoldingReg(0xFFFF); !ok || v != 2 {
+		t.Errorf("failed write changed the map: %d, %v", v, ok)
+	}
+
+	// the four data tables are separate
+	if err := mm.WriteCoils(10, []bool{true, false, true}); err != nil {
+		t.Fatal(err)
+	}
+	if bits, _ := mm.ReadDiscreteInputs(10, 3); slices.Contains(bits, true) {
+		t.Errorf("coil write changed discrete inputs: %v", bits)
+	}
+	if bits, _ := mm.ReadCoils(10, 3); !slices.Equal(bits, []bool{true, false, true}) {
+		t.Errorf("got coils %v", bits)
+	}
+}
+
+func TestUnmappedPolicies(t *testing.T) {
+	mm := NewMemoryMap()
+	mm.PutHoldingReg(1, 7)
+	mm.PutCoil(1, true)
+
+	if regs, err := mm.ReadHoldingRegs(0, 3); err != nil || !slices.Equal(regs, []uint16{0, 7, 0}) {
+		t.Errorf("zero: got %v, %v", regs, err)
+	}
+
+	mm.SetUnmappedPolicy(UnmappedException)
+	if _, err := mm.ReadHoldingRegs(0, 2); !errors.Is(err, ErrUnmappedAddress) {
+		t.Errorf("exception: got %v", err)
+	}
+	if _, err := mm.ReadCoils(1, 2); !errors.Is(err, ErrUnmappedAddress) {
+		t.Errorf("exception: got %v", err)
+	}
+	if _, err := mm.MaskWriteHoldingReg(2, 0, 1); !errors.Is(err, ErrUnmappedAddress) {
+		t.Errorf("mask write of unmapped register: got %v", err)
+	}
+	if regs, err := mm.ReadHoldingRegs(1, 1); err != nil || regs[0] != 7 {
+		t.Errorf("exception, mapped address: got %v, %v", regs, err)
+	}
+	// writes map the addresses
+	if err := mm.WriteHoldingRegs(2, []uint16{8}); err != nil {
+		t.Fatal(err)
+	}
+	if regs, err := mm.ReadHoldingRegs(1, 2); err != nil || !slices.Equal(regs, []uint16{7, 8}) {
+		t.Errorf("exception, written address: got %v, %v", regs, err)
+	}
+
+	mm.SetUnmappedPolicy(UnmappedRandom)
+	regs, err := mm.ReadInputRegs(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Equal(regs, make([]uint16, 100)) {
+		t.Error("random: got only zeros")
+	}
+	if regs, _ := mm.ReadHoldingRegs(1, 1); regs[0] != 7 {
+		t.Errorf("random, mapped address: got %v", regs)
+	}
+}
+
+func TestParseUnmappedPolicy(t *testing.T) {
+	for s, want := range map[string]UnmappedPolicy{"": UnmappedZero, "zero": UnmappedZero, "random": UnmappedRandom, "exception": UnmappedException} {
+		if got, err := ParseUnmappedPolicy(s); err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", s, got, err, want)
+		}
+	}
+	if _, err := ParseUnmappedPolicy("silent"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+}
+
+func TestMemoryMapConsistentReads(t *testing.T) {
+	mm := NewMemoryMap()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range uint16(1000) {
+			_ = mm.WriteHoldingRegs(0, []uint16{i, i, i, i})
+		}
+	}()
+
+	// a range read never sees a partial write
+	for range 1000 {
+		regs, _ := mm.ReadHoldingRegs(0, 4)
+		if regs[0] != regs[1] || regs[1] != regs[2] || regs[2] != regs[3] {
+			t.Fatalf("got torn read %v", regs)
+		}
+	}
+	wg.Wait()
+}
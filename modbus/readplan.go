@@ -0,0 +1,83 @@
+package modbus
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Limits of the quantity of a single read request.
+const (
+	maxReadRegisters = 125
+	maxReadBits      = 2000
+)
+
+// readRequest is a read of consecutive addresses of a slave that serves one or more registers.
+type readRequest struct {
+	slaveAddress uint8
+	registerType string
+	address      uint16
+	quantity     uint16
+	registers    []int // indexes of the served registers
+}
+
+// planReads groups the registers by slave and register type and merges registers that are at most maxGap unused
+// addresses apart into one request, within the limits of 125 registers and 2000 coils or discrete inputs per request.
+// Registers that can't be read, e.g. because of an unknown datatype, are returned as errors by index.
+func planReads(registers []Register, maxGap int) ([]readRequest, map[int]error) {
+	errs := make(map[int]error)
+	var indexes []int
+	for i, r := range registers {
+		if _, _, err := readSpan(r); err != nil {
+			errs[i] = err
+			continue
+		}
+		indexes = append(indexes, i)
+	}
+	slices.SortStableFunc(indexes, func(i, j int) int {
+		a, b := registers[i], registers[j]
+		return cmp.Or(
+			cmp.Compare(a.SlaveAddress, b.SlaveAddress),
+			cmp.Compare(a.RegisterType, b.RegisterType),
+			cmp.Compare(a.Address, b.Address),
+		)
+	})
+
+	var requests []readRequest
+	for _, i := range indexes {
+		r := registers[i]
+		n, limit, _ := readSpan(r)
+		if len(requests) > 0 {
+			last := &requests[len(requests)-1]
+			start, end := int(last.address), int(last.address)+int(last.quantity)
+			if last.slaveAddress == r.SlaveAddress && last.registerType == r.RegisterType &&
+				int(r.Address)-end <= maxGap && max(end, int(r.Address)+n)-start <= limit {
+				last.quantity = uint16(max(end, int(r.Address)+n) - start)
+				last.registers = append(last.registers, i)
+				continue
+			}
+		}
+		requests = append(requests, readRequest{
+			slaveAddress: r.SlaveAddress,
+			registerType: r.RegisterType,
+			address:      r.Address,
+			quantity:     uint16(n),
+			registers:    []int{i},
+		})
+	}
+	return requests, errs
+}
+
+// readSpan returns the number of addresses a read of the register spans and the maximum quantity of a request of its
+// register type.
+func readSpan(r Register) (n int, limit int, err error) {
+	switch r.RegisterType {
+	case "coil", "discrete":
+		return 1, maxReadBits, nil
+	case "holding", "input":
+		n, err := RegisterCount(r.Datatype)
+		return n, maxReadRegisters, err
+	default:
+		return 0, 0, fmt.Errorf("unknown register type: %s", r.RegisterType)
+	}
+}
@@ -0,0 +1,140 @@
+package modbus
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPlanReads(t *testing.T) {
+	tests := []struct {
+		name      string
+		registers []Register
+		maxGap    int
+		want      []readRequest
+	}{
+		{
+			name: "adjacent registers are merged",
+			registers: []Register{
+				{SlaveAddress: 1, Address: 0x10, Datatype: "F32T1234", RegisterType: "input"},
+				{SlaveAddress: 1, Address: 0x12, Datatype: "U16", RegisterType: "input"},
+				{SlaveAddress: 1, Address: 0x13, Datatype: "U64T1234", RegisterType: "input"},
+			},
+			want: []readRequest{
+				{slaveAddress: 1, registerType: "input", address: 0x10, quantity: 7, registers: []int{0, 1, 2}},
+			},
+		},
+		{
+			name: "registers are sorted by address",
+			registers: []Register{
+				{SlaveAddress: 1, Address: 0x12, Datatype: "U16", RegisterType: "holding"},
+				{SlaveAddress: 1, Address: 0x10, Datatype: "F32T1234", RegisterType: "holding"},
+			},
+			want: []readRequest{
+				{slaveAddress: 1, registerType: "holding", address: 0x10, quantity: 3, registers: []int{1, 0}},
+			},
+		},
+		{
+			name: "gaps up to maxGap are read",
+			registers: []Register{
+				{SlaveAddress: 1, Address: 0, Datatype: "U16", RegisterType: "holding"},
+				{SlaveAddress: 1, Address: 4, Datatype: "U16", RegisterType: "holding"},
+				{SlaveAddress: 1, Address: 10, Datatype: "U16", RegisterType: "holding"},
+			},
+			maxGap: 3,
+			want: []readRequest{
+				{slaveAddress: 1, registerType: "holding", address: 0, quantity: 5, registers: []int{0, 1}},
+				{slaveAddress: 1, registerType: "holding", address: 10, quantity: 1, registers: []int{2}},
+			},
+		},
+		{
+			name: "gaps are not read by default",
+			registers: []Register{
+				{SlaveAddress: 1, Address: 0, Datatype: "U16", RegisterType: "holding"},
+				{SlaveAddress: 1, Address: 2, Datatype: "U16", RegisterType: "holding"},
+			},
+			want: []readRequest{
+				{slaveAddress: 1, registerType: "holding", address: 0, quantity: 1, registers: []int{0}},
+				{slaveAddress: 1, registerType: "holding", address: 2, quantity: 1, registers: []int{1}},
+			},
+		},
+		{
+			name: "overlapping registers share a request",
+			registers: []Register{
+				{SlaveAddress: 1, Address: 0, Datatype: "U32T1234", RegisterType: "holding"},
+				{SlaveAddress: 1, Address: 0, Datatype: "U16", RegisterType: "holding"},
+			},
+			want: []readRequest{
+				{slaveAddress: 1, registerType: "holding", address: 0, quantity: 2, registers: []int{0, 1}},
+			},
+		},
+		{
+			name: "slaves and register types are read separately",
+			registers: []Register{
+				{SlaveAddress: 2, Address: 1, Datatype: "U16", RegisterType: "holding"},
+				{SlaveAddress: 1, Address: 0, Datatype: "U16", RegisterType: "input"},
+				{SlaveAddress: 1, Address: 1, Datatype: "U16", RegisterType: "holding"},
+				{SlaveAddress: 1, Address: 2, Datatype: "BOOL", RegisterType: "coil"},
+				{SlaveAddress: 1, Address: 3, Datatype: "BOOL", RegisterType: "coil"},
+			},
+			want: []readRequest{
+				{slaveAddress: 1, registerType: "coil", address: 2, quantity: 2, registers: []int{3, 4}},
+				{slaveAddress: 1, registerType: "holding", address: 1, quantity: 1, registers: []int{2}},
+				{slaveAddress: 1, registerType: "input", address: 0, quantity: 1, registers: []int{1}},
+				{slaveAddress: 2, registerType: "holding", address: 1, quantity: 1, registers: []int{0}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, errs := planReads(tt.registers, tt.maxGap)
+			if len(errs) > 0 {
+				t.Fatalf("errors: %v", errs)
+			}
+			if !slices.EqualFunc(got, tt.want, equalRequests) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanReadsLimits(t *testing.T) {
+	// 65 F32 registers occupy 130 addresses, more than a single request may read
+	var registers []Register
+	for a := uint16(0); a < 130; a += 2 {
+		registers = append(registers, Register{SlaveAddress: 1, Address: a, Datatype: "F32T1234", RegisterType: "input"})
+	}
+	got, _ := planReads(registers, 0)
+	if len(got) != 2 || got[0].quantity != 124 || got[1].address != 124 || got[1].quantity != 6 {
+		t.Errorf("registers: got %+v", got)
+	}
+
+	var coils []Register
+	for a := uint16(0); a < 2001; a++ {
+		coils = append(coils, Register{SlaveAddress: 1, Address: a, Datatype: "BOOL", RegisterType: "coil"})
+	}
+	got, _ = planReads(coils, 0)
+	if len(got) != 2 || got[0].quantity != 2000 || got[1].address != 2000 || got[1].quantity != 1 {
+		t.Errorf("coils: got %d requests", len(got))
+	}
+}
+
+func TestPlanReadsErrors(t *testing.T) {
+	registers := []Register{
+		{SlaveAddress: 1, Address: 0, Datatype: "X16", RegisterType: "holding"},
+		{SlaveAddress: 1, Address: 1, Datatype: "U16", RegisterType: "table"},
+		{SlaveAddress: 1, Address: 2, Datatype: "U16", RegisterType: "holding"},
+	}
+	got, errs := planReads(registers, 10)
+	if len(errs) != 2 || errs[0] == nil || errs[1] == nil {
+		t.Errorf("got errors %v, want errors of registers 0 and 1", errs)
+	}
+	want := []readRequest{{slaveAddress: 1, registerType: "holding", address: 2, quantity: 1, registers: []int{2}}}
+	if !slices.EqualFunc(got, want, equalRequests) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func equalRequests(a, b readRequest) bool {
+	return a.slaveAddress == b.slaveAddress && a.registerType == b.registerType && a.address == b.address &&
+		a.quantity == b.quantity && slices.Equal(a.registers, b.registers)
+}
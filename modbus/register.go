@@ -1,10 +1,190 @@
 package modbus
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Severities of a flag.
+const (
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// Access modes of a register.
+const (
+	AccessReadOnly  = "ro"
+	AccessWriteOnly = "wo"
+	AccessReadWrite = "rw"
+)
+
 type Register struct {
-	SlaveAddress uint8  // the slave address to which this register belongs
-	Address      uint16 // the address of this register
-	Datatype     string // SINT16T12 | F32T1234 | T64T1234
-	RegisterType string // coil | discrete | input | holding
-	Action       string // read | write
+	SlaveAddress uint8       // the slave address to which this register belongs
+	Address      uint16      // the address of this register
+	Datatype     string      // BOOL | U16 | S16 | U32T1234 | S64T3412 | F64T4321 | ASCII8 | BCD32 | ..., see encoding.go
+	RegisterType string      // coil | discrete | input | holding
+	Action       string      // read | write
+	Name         string      // e.g. Grid voltage L1
+	Unit         string      // unit of the scaled value, e.g. V
+	Scale        float64     // the scaled value is the raw value * Scale + Offset, 0 counts as 1
+	Offset       float64     // see Scale
+	Description  string      // free text
+	Min, Max     *float64    // bounds of the scaled value, nil if unbounded
+	Access       string      // ro | wo | rw, rw if empty
+	Enum         []EnumValue // labels of the raw values of a state register
+	Flags        []Flag      // names of the bits of a bitfield register
+	Init         *float64    // simulator only: the initial scaled value of the register, nil if the DSL doesn't define one
 	RawData      any
+	Err          error // adapter only: the error reading the register, RawData is nil then
+}
+
+// EnumValue is the label of a raw register value, e.g. 2 = Running.
+type EnumValue struct {
+	Value uint64
+	Label string
+}
+
+// Flag is the name of a bit of a bitfield register, e.g. an alarm flag.
+type Flag struct {
+	Bit      int
+	Name     string
+	Severity string // info | warning | error
+}
+
+// Label returns the name and the unit of the register, e.g. "Grid voltage L1 [V]", or its type and address if it
+// has no name.
+func (r Register) Label() string {
+	label := r.Name
+	if label == "" {
+		label = fmt.Sprintf("%s 0x%X", r.RegisterType, r.Address)
+	}
+	if r.Unit != "" {
+		label += " [" + r.Unit + "]"
+	}
+	return label
+}
+
+// Scaled converts a raw register value into the register's unit.
+func (r Register) Scaled(raw float64) float64 {
+	if r.Scale == 0 {
+		return raw + r.Offset
+	}
+	return raw*r.Scale + r.Offset
+}
+
+// Unscaled converts a value in the register's unit into the raw register value.
+func (r Register) Unscaled(v float64) float64 {
+	if r.Scale == 0 {
+		return v - r.Offset
+	}
+	return (v - r.Offset) / r.Scale
+}
+
+// Value returns the scaled value of RawData. It reports false if RawData is not a number or a bool.
+func (r Register) Value() (float64, bool) {
+	raw, ok := r.rawValue()
+	return r.Scaled(raw), ok
+}
+
+// rawValue returns RawData as a float64. It reports false if RawData is not a number or a bool.
+func (r Register) rawValue() (float64, bool) {
+	return ToFloat64(r.RawData)
+}
+
+// EnumLabel returns the label of the raw value of RawData. It reports false if the value has no label.
+func (r Register) EnumLabel() (string, bool) {
+	raw, ok := r.rawValue()
+	if !ok || raw < 0 {
+		return "", false
+	}
+	for _, e := range r.Enum {
+		if e.Value == uint64(raw) {
+			return e.Label, true
+		}
+	}
+	return "", false
+}
+
+// ActiveFlags returns the flags whose bits are set in the raw value of RawData.
+func (r Register) ActiveFlags() (flags []Flag) {
+	raw, ok := r.rawValue()
+	if !ok || raw < 0 {
+		return nil
+	}
+	for _, f := range r.Flags {
+		if uint64(raw)&(1<<f.Bit) != 0 {
+			flags = append(flags, f)
+		}
+	}
+	return
+}
+
+// Display returns RawData decoded for humans: the read error, the label of an enum register's value, the names of a
+// bitfield register's active flags or the formatted value.
+func (r Register) Display() string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("error: %v", r.Err)
+	case len(r.Enum) > 0:
+		if label, ok := r.EnumLabel(); ok {
+			return label
+		}
+		return fmt.Sprintf("unknown (%v)", r.RawData)
+	case len(r.Flags) > 0:
+		var names []string
+		for _, f := range r.ActiveFlags() {
+			names = append(names, f.Name)
+		}
+		if len(names) == 0 {
+			return "-"
+		}
+		return strings.Join(names, ", ")
+	default:
+		return r.FormatValue()
+	}
+}
+
+// FormatValue returns the scaled value of RawData, RawData as is if it is a bool or not a number.
+func (r Register) FormatValue() string {
+	if _, isBool := r.RawData.(bool); !isBool {
+		if v, ok := r.Value(); ok {
+			// float32 values are formatted with their own precision rather than the float64 conversion's digits
+			bitSize := 64
+			if _, isFloat32 := r.RawData.(float32); isFloat32 {
+				bitSize = 32
+			}
+			return strconv.FormatFloat(v, 'f', -1, bitSize)
+		}
+	}
+	return fmt.Sprintf("%v", r.RawData)
+}
+
+// CheckRange returns an error if the scaled value v is outside the register's bounds.
+func (r Register) CheckRange(v float64) error {
+	if r.Min != nil && v < *r.Min || r.Max != nil && v > *r.Max {
+		return fmt.Errorf("%s: %v out of range %s", r.Label(), v, r.describeBounds())
+	}
+	return nil
+}
+
+func (r Register) describeBounds() string {
+	bound := func(b *float64, unbounded string) string {
+		if b == nil {
+			return unbounded
+		}
+		return strconv.FormatFloat(*b, 'f', -1, 64)
+	}
+	return fmt.Sprintf("[%s, %s]", bound(r.Min, "-inf"), bound(r.Max, "+inf"))
+}
+
+// Readable reports whether the master may read the register.
+func (r Register) Readable() bool {
+	return r.Access != AccessWriteOnly
+}
+
+// Writable reports whether the master may write the register. Discrete inputs and input registers are never writable.
+func (r Register) Writable() bool {
+	return r.Access != AccessReadOnly && (r.RegisterType == "coil" || r.RegisterType == "holding")
 }
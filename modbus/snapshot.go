@@ -0,0 +1,92 @@
+package modbus
+
+// MemoryBlock is a run of consecutive mapped addresses of a data table and their values, coils and discrete inputs
+// as 0 and 1.
+type MemoryBlock struct {
+	Address uint16   `json:"address"`
+	Values  []uint16 `json:"values"`
+}
+
+// MemorySnapshot is the content of the mapped addresses of a memory map.
+type MemorySnapshot struct {
+	Coils          []MemoryBlock `json:"coils,omitempty"`
+	DiscreteInputs []MemoryBlock `json:"discrete_inputs,omitempty"`
+	InputRegs      []MemoryBlock `json:"input_registers,omitempty"`
+	HoldingRegs    []MemoryBlock `json:"holding_registers,omitempty"`
+}
+
+// Snapshot returns the content of the memory map's mapped addresses.
+func (mm *MemoryMap) Snapshot() MemorySnapshot {
+	mm.lock.RLock()
+	defer mm.lock.RUnlock()
+	return MemorySnapshot{
+		Coils:          mm.coils.blocks(bitToUint16),
+		DiscreteInputs: mm.discreteInputs.blocks(bitToUint16),
+		InputRegs:      mm.inputRegs.blocks(identity),
+		HoldingRegs:    mm.holdingRegs.blocks(identity),
+	}
+}
+
+// Restore writes the snapshot's blocks into the memory map. Addresses not contained in the snapshot keep their
+// values, so that registers added to the slave since the snapshot was taken stay mapped. Nothing is changed if a
+// block exceeds the address space.
+func (mm *MemoryMap) Restore(snapshot MemorySnapshot) error {
+	for _, blocks := range [][]MemoryBlock{snapshot.Coils, snapshot.DiscreteInputs, snapshot.InputRegs, snapshot.HoldingRegs} {
+		for _, b := range blocks {
+			if err := checkRange(b.Address, len(b.Values)); err != nil {
+				return err
+			}
+		}
+	}
+
+	mm.lock.Lock()
+	defer mm.lock.Unlock()
+	mm.coils.restore(snapshot.Coils, uint16ToBit)
+	mm.discreteInputs.restore(snapshot.DiscreteInputs, uint16ToBit)
+	mm.inputRegs.restore(snapshot.InputRegs, identity)
+	mm.holdingRegs.restore(snapshot.HoldingRegs, identity)
+	return nil
+}
+
+// blocks returns the runs of mapped addresses with their values converted by conv.
+func (a *area[T]) blocks(conv func(T) uint16) (blocks []MemoryBlock) {
+	for address := 0; address < addressSpace; address++ {
+		if a.mapped[address/64] == 0 {
+			address += 63 // skip the whole unmapped word
+			continue
+		}
+		if !a.isMapped(uint16(address)) {
+			continue
+		}
+		if n := len(blocks); n == 0 || int(blocks[n-1].Address)+len(blocks[n-1].Values) != address {
+			blocks = append(blocks, MemoryBlock{Address: uint16(address)})
+		}
+		b := &blocks[len(blocks)-1]
+		b.Values = append(b.Values, conv(a.values[address]))
+	}
+	return
+}
+
+// restore writes the blocks' values converted by conv. The blocks must fit into the address space.
+func (a *area[T]) restore(blocks []MemoryBlock, conv func(uint16) T) {
+	for _, b := range blocks {
+		for i, v := range b.Values {
+			a.put(b.Address+uint16(i), conv(v))
+		}
+	}
+}
+
+func bitToUint16(v bool) uint16 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func uint16ToBit(v uint16) bool {
+	return v != 0
+}
+
+func identity(v uint16) uint16 {
+	return v
+}
@@ -0,0 +1,71 @@
+package modbus
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	mm := NewMemoryMap()
+	mm.PutCoil(1, true)
+	mm.PutCoil(2, false)
+	mm.PutHoldingReg(0x10, 1)
+	mm.PutHoldingReg(0x11, 2)
+	mm.PutInputReg(0xFFFF, 3)
+	snapshot := mm.Snapshot()
+
+	want := MemorySnapshot{
+		Coils:       []MemoryBlock{{Address: 1, Values: []uint16{1, 0}}},
+		InputRegs:   []MemoryBlock{{Address: 0xFFFF, Values: []uint16{3}}},
+		HoldingRegs: []MemoryBlock{{Address: 0x10, Values: []uint16{1, 2}}},
+	}
+	if !equalSnapshots(snapshot, want) {
+		t.Fatalf("got snapshot %+v, want %+v", snapshot, want)
+	}
+
+	// a register added since the snapshot was taken stays mapped
+	seeded := NewMemoryMap()
+	seeded.SetUnmappedPolicy(UnmappedException)
+	seeded.PutHoldingReg(0x10, 9)
+	seeded.PutHoldingReg(0x20, 5)
+	if err := seeded.Restore(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if regs, err := seeded.ReadHoldingRegs(0x10, 2); err != nil || !slices.Equal(regs, []uint16{1, 2}) {
+		t.Errorf("holding 0x10: got %v, %v, want [1 2]", regs, err)
+	}
+	if regs, err := seeded.ReadHoldingRegs(0x20, 1); err != nil || regs[0] != 5 {
+		t.Errorf("holding 0x20: got %v, %v, want [5]", regs, err)
+	}
+	if coils, err := seeded.ReadCoils(1, 2); err != nil || !slices.Equal(coils, []bool{true, false}) {
+		t.Errorf("coils: got %v, %v", coils, err)
+	}
+	if _, err := seeded.ReadHoldingRegs(0x12, 1); !errors.Is(err, ErrUnmappedAddress) {
+		t.Errorf("holding 0x12: got %v, want unmapped", err)
+	}
+}
+
+func TestRestoreOutOfRange(t *testing.T) {
+	mm := NewMemoryMap()
+	mm.PutHoldingReg(0, 7)
+	err := mm.Restore(MemorySnapshot{
+		HoldingRegs: []MemoryBlock{{Address: 0, Values: []uint16{1}}, {Address: 0xFFFF, Values: []uint16{1, 2}}},
+	})
+	if !errors.Is(err, ErrAddressOutOfRange) {
+		t.Fatalf("got %v, want out of range", err)
+	}
+	if v, _ := mm.GetHoldingReg(0); v != 7 {
+		t.Errorf("got holding 0 = %d, want unchanged 7", v)
+	}
+}
+
+func equalSnapshots(a, b MemorySnapshot) bool {
+	equal := func(a, b []MemoryBlock) bool {
+		return slices.EqualFunc(a, b, func(x, y MemoryBlock) bool {
+			return x.Address == y.Address && slices.Equal(x.Values, y.Values)
+		})
+	}
+	return equal(a.Coils, b.Coils) && equal(a.DiscreteInputs, b.DiscreteInputs) &&
+		equal(a.InputRegs, b.InputRegs) && equal(a.HoldingRegs, b.HoldingRegs)
+}
@@ -0,0 +1,265 @@
+// Package modsimprotest runs modsimpro servers in-process, so that modbus masters can be tested against simulated
+// slaves without starting the simulator binary:
+//
+//	srv := modsimprotest.NewServer(t, 101)
+//	srv.Slave(101).HoldingRegs(0x7E3, 0).InputRegs(0x10, 230, 231)
+//	srv.Slave(102).FromDSL("testdata/inverter/register.dsl")
+//
+//	// run the code under test against srv.URL()
+//
+//	srv.AssertWritten(101, 0x7E3, 1)
+package modsimprotest
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rwirdemann/modsimpro"
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// Server is a running ModbusServer that records every request it receives.
+type Server struct {
+	*modsimpro.ModbusServer
+	t      testing.TB
+	serial modbus.Serial
+	lock   sync.Mutex
+	events []modsimpro.Event
+}
+
+// testLogger passes the server log to the test log.
+type testLogger struct {
+	t testing.TB
+}
+
+func (l testLogger) Append(s string) {
+	l.t.Log(s)
+}
+
+// NewServer starts a modbus TCP server on an ephemeral localhost port with the given slaves online. The server is
+// stopped when the test finishes.
+func NewServer(t testing.TB, slaveIDs ...uint8) *Server {
+	t.Helper()
+	serial := modbus.Serial{Url: "tcp://127.0.0.1:0"}
+	for _, id := range slaveIDs {
+		serial.Slaves = append(serial.Slaves, modbus.Slave{Address: id})
+	}
+	return NewServerWithConfig(t, serial)
+}
+
+// NewServerWithConfig starts a server for the configuration with all of its slaves online. Use port 0 in the URL to
+// listen on an ephemeral port. The server is stopped when the test finishes.
+func NewServerWithConfig(t testing.TB, serial modbus.Serial) *Server {
+	t.Helper()
+	ms := modsimpro.NewModbusServer(serial, testLogger{t: t})
+	if ms == nil {
+		t.Fatalf("modsimprotest: invalid url: %s", serial.Url)
+	}
+
+	s := &Server{ModbusServer: ms, t: t, serial: serial}
+	ms.Subscribe(s.record)
+	if err := ms.Start(); err != nil {
+		t.Fatalf("modsimprotest: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := ms.Close(); err != nil {
+			t.Errorf("modsimprotest: %v", err)
+		}
+	})
+
+	for _, slave := range serial.Slaves {
+		ms.Connect(int(slave.Address))
+	}
+	return s
+}
+
+// URL returns the URL clients connect to, e.g. tcp://127.0.0.1:40123.
+func (s *Server) URL() string {
+	scheme, _, _ := strings.Cut(s.serial.Url, "://")
+	if addr := s.Addr(); addr != nil {
+		return scheme + "://" + addr.String()
+	}
+	return s.serial.Url
+}
+
+// Port returns the port the server listens on.
+func (s *Server) Port() int {
+	switch addr := s.Addr().(type) {
+	case *net.TCPAddr:
+		return addr.Port
+	case *net.UDPAddr:
+		return addr.Port
+	default:
+		return 0
+	}
+}
+
+func (s *Server) record(e modsimpro.Event) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, e)
+}
+
+// Events returns all requests the server has received so far.
+func (s *Server) Events() []modsimpro.Event {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return slices.Clone(s.events)
+}
+
+// Writes returns the write requests the slave has received and executed so far. Writes to an offline slave or answered
+// with an exception are not included, writes whose response has been dropped by a fault are.
+func (s *Server) Writes(slaveID uint8) (writes []modsimpro.Event) {
+	for _, e := range s.Events() {
+		if e.SlaveID == slaveID && e.IsWrite() && e.Executed {
+			writes = append(writes, e)
+		}
+	}
+	return
+}
+
+// Reset forgets all recorded requests.
+func (s *Server) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = nil
+}
+
+// Slave returns the preloader of the slave's memory map. Unknown slaves are added to the server and brought online.
+func (s *Server) Slave(slaveID uint8) *Slave {
+	mm := s.MemoryMap(int(slaveID))
+	if mm == nil {
+		s.Connect(int(slaveID))
+		mm = s.MemoryMap(int(slaveID))
+	}
+	return &Slave{server: s, id: slaveID, mm: mm}
+}
+
+// AssertWritten checks that the slave has received a successful register write that set the registers starting at
+// address to values.
+func (s *Server) AssertWritten(slaveID uint8, address uint16, values ...uint16) {
+	s.t.Helper()
+	if !s.written(slaveID, false, address, values) {
+		s.t.Errorf("modsimprotest: slave %d: registers at 0x%X not written with %v, writes: %s", slaveID, address, values, s.describeWrites(slaveID))
+	}
+}
+
+// AssertCoilsWritten checks that the slave has received a successful coil write that set the coils starting at
+// address to values.
+func (s *Server) AssertCoilsWritten(slaveID uint8, address uint16, values ...bool) {
+	s.t.Helper()
+	if !s.written(slaveID, true, address, boolsToUint16s(values)) {
+		s.t.Errorf("modsimprotest: slave %d: coils at 0x%X not written with %v, writes: %s", slaveID, address, values, s.describeWrites(slaveID))
+	}
+}
+
+// AssertNotWritten checks that no successful write request of the slave has touched the register or coil at address.
+func (s *Server) AssertNotWritten(slaveID uint8, address uint16) {
+	s.t.Helper()
+	for _, e := range s.Writes(slaveID) {
+		if address >= e.Address && int(address) < int(e.Address)+int(e.Quantity) {
+			s.t.Errorf("modsimprotest: slave %d: address 0x%X written: %s", slaveID, address, describe(e))
+			return
+		}
+	}
+}
+
+// WaitWritten waits up to timeout for a register write that sets the registers starting at address to values and
+// reports whether it has been received.
+func (s *Server) WaitWritten(timeout time.Duration, slaveID uint8, address uint16, values ...uint16) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if s.written(slaveID, false, address, values) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// AssertHoldingRegs checks the current values of the slave's holding registers starting at address.
+func (s *Server) AssertHoldingRegs(slaveID uint8, address uint16, values ...uint16) {
+	s.t.Helper()
+	got, err := s.memoryMap(slaveID).ReadHoldingRegs(address, uint16(len(values)))
+	if err != nil {
+		s.t.Errorf("modsimprotest: slave %d: %v", slaveID, err)
+		return
+	}
+	if !slices.Equal(got, values) {
+		s.t.Errorf("modsimprotest: slave %d: holding registers at 0x%X are %v, want %v", slaveID, address, got, values)
+	}
+}
+
+// AssertCoils checks the current values of the slave's coils starting at address.
+func (s *Server) AssertCoils(slaveID uint8, address uint16, values ...bool) {
+	s.t.Helper()
+	got, err := s.memoryMap(slaveID).ReadCoils(address, uint16(len(values)))
+	if err != nil {
+		s.t.Errorf("modsimprotest: slave %d: %v", slaveID, err)
+		return
+	}
+	if !slices.Equal(got, values) {
+		s.t.Errorf("modsimprotest: slave %d: coils at 0x%X are %v, want %v", slaveID, address, got, values)
+	}
+}
+
+// memoryMap returns the slave's memory map, an empty one for unknown slaves.
+func (s *Server) memoryMap(slaveID uint8) *modbus.MemoryMap {
+	if mm := s.MemoryMap(int(slaveID)); mm != nil {
+		return mm
+	}
+	return modbus.NewMemoryMap()
+}
+
+// written reports whether one of the slave's successful coil or register writes set the addresses starting at address
+// to values.
+func (s *Server) written(slaveID uint8, coils bool, address uint16, values []uint16) bool {
+	for _, e := range s.Writes(slaveID) {
+		if isCoilWrite(e) != coils || address < e.Address || int(address)+len(values) > int(e.Address)+len(e.Values) {
+			continue
+		}
+		// the values of mask write register are masks
+		offset := int(address - e.Address)
+		if e.FunctionCode != 0x16 && slices.Equal(e.Values[offset:offset+len(values)], values) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) describeWrites(slaveID uint8) string {
+	writes := s.Writes(slaveID)
+	if len(writes) == 0 {
+		return "none"
+	}
+	descriptions := make([]string, len(writes))
+	for i, e := range writes {
+		descriptions[i] = describe(e)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+func describe(e modsimpro.Event) string {
+	return fmt.Sprintf("fc %X at 0x%X %v", e.FunctionCode, e.Address, e.Values)
+}
+
+func isCoilWrite(e modsimpro.Event) bool {
+	return e.FunctionCode == 0x05 || e.FunctionCode == 0x0F
+}
+
+func boolsToUint16s(values []bool) []uint16 {
+	out := make([]uint16, len(values))
+	for i, v := range values {
+		if v {
+			out[i] = 1
+		}
+	}
+	return out
+}
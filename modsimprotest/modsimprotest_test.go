@@ -0,0 +1,147 @@
+package modsimprotest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+	sv "github.com/simonvetter/modbus"
+)
+
+// recorder is a testing.TB that records the failures reported by assertions instead of failing the test.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Helper() {}
+
+func newClient(t *testing.T, url string, slaveID uint8) *sv.ModbusClient {
+	t.Helper()
+	client, err := sv.NewClient(&sv.ClientConfiguration{URL: url, Timeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	_ = client.SetUnitId(slaveID)
+	return client
+}
+
+func TestAssertWritten(t *testing.T) {
+	rec := &recorder{TB: t}
+	srv := NewServer(rec, 1)
+	client := newClient(t, srv.URL(), 1)
+
+	if err := client.WriteRegisters(0x10, []uint16{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteCoil(5, true); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.AssertWritten(1, 0x10, 1, 2, 3)
+	srv.AssertWritten(1, 0x11, 2, 3)
+	srv.AssertCoilsWritten(1, 5, true)
+	srv.AssertNotWritten(1, 0x13)
+	srv.AssertHoldingRegs(1, 0x10, 1, 2, 3)
+	if len(rec.failures) > 0 {
+		t.Fatalf("unexpected failures: %v", rec.failures)
+	}
+
+	srv.AssertWritten(1, 0x10, 9)
+	srv.AssertNotWritten(1, 0x12)
+	srv.AssertCoilsWritten(1, 0x10, true)
+	if len(rec.failures) != 3 {
+		t.Fatalf("got failures %v, want 3", rec.failures)
+	}
+}
+
+func TestWaitWritten(t *testing.T) {
+	srv := NewServer(t, 1)
+	client := newClient(t, srv.URL(), 1)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = client.WriteRegister(0x20, 42)
+	}()
+	if !srv.WaitWritten(time.Second, 1, 0x20, 42) {
+		t.Fatal("write not received")
+	}
+	if srv.WaitWritten(50*time.Millisecond, 1, 0x20, 43) {
+		t.Fatal("got write of 43")
+	}
+}
+
+func TestWritesToOfflineSlave(t *testing.T) {
+	rec := &recorder{TB: t}
+	srv := NewServer(rec, 1)
+	srv.Slave(1).Offline()
+	client := newClient(t, srv.URL(), 1)
+
+	if err := client.WriteRegister(0x10, 7); err == nil {
+		t.Fatal("offline slave answered")
+	}
+	if writes := srv.Writes(1); len(writes) != 0 {
+		t.Fatalf("got writes %v", writes)
+	}
+	if srv.WaitWritten(50*time.Millisecond, 1, 0x10, 7) {
+		t.Fatal("write to offline slave reported as written")
+	}
+	srv.AssertNotWritten(1, 0x10)
+	srv.AssertWritten(1, 0x10, 7)
+	if len(rec.failures) != 1 {
+		t.Fatalf("got failures %v, want 1", rec.failures)
+	}
+}
+
+func TestWritesWithDroppedResponse(t *testing.T) {
+	srv := NewServerWithConfig(t, modbus.Serial{
+		Url:    "tcp://127.0.0.1:0",
+		Slaves: []modbus.Slave{{Address: 1, Faults: []modbus.FaultConfig{{Type: modbus.FaultDrop}}}},
+	})
+	client := newClient(t, srv.URL(), 1)
+
+	if err := client.WriteRegister(0x10, 7); err == nil {
+		t.Fatal("dropped response received")
+	}
+	if !srv.WaitWritten(time.Second, 1, 0x10, 7) {
+		t.Fatal("write with dropped response not recorded")
+	}
+	srv.AssertHoldingRegs(1, 0x10, 7)
+}
+
+func TestWritesRejectedWithException(t *testing.T) {
+	srv := NewServer(t, 1)
+	srv.Slave(1).DSL(strings.NewReader("read address 10 type U16 holding access=ro init=5\n"))
+	client := newClient(t, srv.URL(), 1)
+
+	if err := client.WriteRegister(0x10, 7); err == nil {
+		t.Fatal("write to read-only register succeeded")
+	}
+	srv.AssertNotWritten(1, 0x10)
+	srv.AssertHoldingRegs(1, 0x10, 5)
+}
+
+func TestRegisters(t *testing.T) {
+	srv := NewServer(t, 1)
+	srv.Slave(1).Registers([]modbus.Register{
+		{SlaveAddress: 1, Address: 0x10, Datatype: "S16", RegisterType: "holding", RawData: int16(-2)},
+		{SlaveAddress: 1, Address: 0x11, Datatype: "S32T1234", RegisterType: "holding", RawData: int32(-3)},
+		{SlaveAddress: 1, Address: 0x13, Datatype: "ASCII2", RegisterType: "holding", RawData: "OK"},
+		{SlaveAddress: 1, Address: 0x15, Datatype: "BOOL", RegisterType: "coil", RawData: true},
+		{SlaveAddress: 2, Address: 0x20, Datatype: "U16", RegisterType: "holding", RawData: uint16(1)},
+	})
+
+	srv.AssertHoldingRegs(1, 0x10, 0xFFFE, 0xFFFF, 0xFFFD, 0x4F4B, 0)
+	srv.AssertCoils(1, 0x15, true)
+	srv.AssertHoldingRegs(1, 0x20, 0)
+}
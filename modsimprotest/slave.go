@@ -0,0 +1,112 @@
+package modsimprotest
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// Slave preloads the memory map of a simulated slave. Its methods return the Slave, so that calls can be chained.
+// Failures are reported through the test's Fatal.
+type Slave struct {
+	server *Server
+	id     uint8
+	mm     *modbus.MemoryMap
+}
+
+// Online brings the slave online.
+func (sl *Slave) Online() *Slave {
+	sl.server.Connect(int(sl.id))
+	return sl
+}
+
+// Offline takes the slave offline.
+func (sl *Slave) Offline() *Slave {
+	sl.server.Disconnect(int(sl.id))
+	return sl
+}
+
+// Coils sets the coils starting at address.
+func (sl *Slave) Coils(address uint16, values ...bool) *Slave {
+	sl.server.t.Helper()
+	sl.check(sl.mm.WriteCoils(address, values))
+	return sl
+}
+
+// DiscreteInputs sets the discrete inputs starting at address.
+func (sl *Slave) DiscreteInputs(address uint16, values ...bool) *Slave {
+	sl.server.t.Helper()
+	sl.check(sl.mm.WriteDiscreteInputs(address, values))
+	return sl
+}
+
+// InputRegs sets the input registers starting at address.
+func (sl *Slave) InputRegs(address uint16, values ...uint16) *Slave {
+	sl.server.t.Helper()
+	sl.check(sl.mm.WriteInputRegs(address, values))
+	return sl
+}
+
+// HoldingRegs sets the holding registers starting at address.
+func (sl *Slave) HoldingRegs(address uint16, values ...uint16) *Slave {
+	sl.server.t.Helper()
+	sl.check(sl.mm.WriteHoldingRegs(address, values))
+	return sl
+}
+
+// Value encodes v with the datatype, e.g. F32T1234, into the registers of the register type starting at address.
+func (sl *Slave) Value(registerType string, address uint16, datatype string, v float64) *Slave {
+	sl.server.t.Helper()
+	sl.check(sl.mm.WriteValue(registerType, address, datatype, v))
+	return sl
+}
+
+// Registers sets each of the registers to its RawData. Registers of other slaves are skipped.
+func (sl *Slave) Registers(registers []modbus.Register) *Slave {
+	sl.server.t.Helper()
+	for _, r := range registers {
+		if r.SlaveAddress != sl.id {
+			continue
+		}
+		switch v := r.RawData.(type) {
+		case nil:
+			sl.Value(r.RegisterType, r.Address, r.Datatype, 0)
+		case string:
+			sl.check(sl.mm.WriteString(r.RegisterType, r.Address, r.Datatype, v))
+		default:
+			f, ok := modbus.ToFloat64(v)
+			if !ok {
+				sl.check(fmt.Errorf("register 0x%X: unsupported value type %T", r.Address, v))
+			}
+			sl.Value(r.RegisterType, r.Address, r.Datatype, f)
+		}
+	}
+	return sl
+}
+
+// FromDSL maps the registers defined in the register.dsl file at path with their initial values, see
+// ModbusServer.SeedRegisters. Writes violating the definitions' access modes and bounds are rejected from then on.
+func (sl *Slave) FromDSL(path string) *Slave {
+	sl.server.t.Helper()
+	registers, err := modbus.LoadRegisterDSL(path, sl.id)
+	sl.check(err)
+	sl.check(sl.server.SeedRegisters(int(sl.id), registers))
+	return sl
+}
+
+// DSL is FromDSL for register definitions read from r.
+func (sl *Slave) DSL(r io.Reader) *Slave {
+	sl.server.t.Helper()
+	registers, err := modbus.ParseRegisterDSL(r, sl.id)
+	sl.check(err)
+	sl.check(sl.server.SeedRegisters(int(sl.id), registers))
+	return sl
+}
+
+func (sl *Slave) check(err error) {
+	sl.server.t.Helper()
+	if err != nil {
+		sl.server.t.Fatalf("modsimprotest: slave %d: %v", sl.id, err)
+	}
+}
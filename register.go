@@ -0,0 +1,113 @@
+package modsimpro
+
+import (
+	"fmt"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// SeedRegisters maps the registers of the slave's register definitions by writing their initial values, 0 for
+// registers without one, to the slave's memory map. With the unmapped policy "exception" reads of all other
+// addresses are answered with illegal data address. From then on writes of the master to read-only registers are
+// answered with illegal data address and writes of values outside of a register's bounds with illegal data value.
+func (s *ModbusServer) SeedRegisters(slaveID int, registers []modbus.Register) error {
+	mm := s.MemoryMap(slaveID)
+	if mm == nil {
+		return ErrNotFound
+	}
+	for _, r := range registers {
+		if modbus.IsStringDatatype(r.Datatype) {
+			if err := mm.WriteString(r.RegisterType, r.Address, r.Datatype, ""); err != nil {
+				return fmt.Errorf("register 0x%X: %w", r.Address, err)
+			}
+			continue
+		}
+		var v float64
+		if r.Init != nil {
+			v = r.Unscaled(*r.Init)
+		}
+		if err := mm.WriteValue(r.RegisterType, r.Address, r.Datatype, v); err != nil {
+			return fmt.Errorf("register 0x%X: %w", r.Address, err)
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.registers[slaveID] = registers
+	return nil
+}
+
+// slaveRegisters returns the register definitions of the slave.
+func (s *ModbusServer) slaveRegisters(slaveID int) []modbus.Register {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.registers[slaveID]
+}
+
+// checkWrite rejects a write request that touches a read-only register of the slave or that would set a register to
+// a value outside of its bounds.
+func (s *ModbusServer) checkWrite(slaveID int, mm *modbus.MemoryMap, req *pdu) error {
+	registers := s.slaveRegisters(slaveID)
+	if len(registers) == 0 || !isWriteFunction(req.functionCode) {
+		return nil
+	}
+
+	e := decodeEvent(req)
+	registerType := "holding"
+	if req.functionCode == fcWriteSingleCoil || req.functionCode == fcWriteMultipleCoils {
+		registerType = "coil"
+	}
+	for _, r := range registers {
+		n, err := modbus.RegisterCount(r.Datatype)
+		if err != nil || r.RegisterType != registerType {
+			continue
+		}
+		if int(e.Address)+int(e.Quantity) <= int(r.Address) || int(r.Address)+n <= int(e.Address) {
+			continue
+		}
+		if !r.Writable() {
+			return fmt.Errorf("%w: %s is read-only", ErrIllegalDataAddress, r.Label())
+		}
+		if r.Min == nil && r.Max == nil {
+			continue
+		}
+		// registers whose value can't be determined, e.g. because of unmapped parts, are not checked
+		if v, err := writtenValue(mm, r, n, e); err == nil {
+			if err := r.CheckRange(v); err != nil {
+				return fmt.Errorf("%w: %v", ErrIllegalDataValue, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writtenValue returns the scaled value the n registers of r will have after the write request described by e.
+func writtenValue(mm *modbus.MemoryMap, r modbus.Register, n int, e Event) (float64, error) {
+	if r.RegisterType == "coil" {
+		i := int(r.Address) - int(e.Address)
+		if i >= len(e.Values) {
+			return 0, ErrIllegalDataValue
+		}
+		return r.Scaled(float64(e.Values[i])), nil
+	}
+
+	regs, err := mm.ReadHoldingRegs(r.Address, uint16(n))
+	if err != nil {
+		return 0, err
+	}
+	for i := range regs {
+		j := int(r.Address) + i - int(e.Address)
+		switch {
+		case j < 0 || j >= int(e.Quantity):
+		case e.FunctionCode == fcMaskWriteRegister && len(e.Values) == 2:
+			regs[i] = regs[i]&e.Values[0] | e.Values[1]&^e.Values[0]
+		case j < len(e.Values):
+			regs[i] = e.Values[j]
+		}
+	}
+	raw, err := modbus.DecodeFloat(r.Datatype, regs)
+	if err != nil {
+		return 0, err
+	}
+	return r.Scaled(raw), nil
+}
@@ -0,0 +1,360 @@
+package modsimpro
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+const (
+	maxRTUFrameLength int = 256
+
+	ErrBadCRC     Error = "bad crc"
+	ErrShortFrame Error = "short frame"
+)
+
+// rtuLink is a serial line, either a serial device or the master side of a pseudo-terminal.
+type rtuLink interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// rtuTransport implements the RTU framing: frames are delimited by at least 3.5 character times of silence on the
+// line and protected by a CRC16.
+type rtuTransport struct {
+	link         rtuLink
+	charTime     time.Duration
+	t35          time.Duration
+	lastActivity time.Time
+}
+
+func newRTUTransport(link rtuLink, serial modbus.Serial) *rtuTransport {
+	t := &rtuTransport{link: link, charTime: serialCharTime(serial)}
+	if speed(serial) >= 19200 {
+		// for baud rates equal to or greater than 19200 bauds, a fixed value of 1750 uS is specified for t3.5
+		t.t35 = 1750 * time.Microsecond
+	} else {
+		t.t35 = t.charTime * 35 / 10
+	}
+	return t
+}
+
+// ReadRequest reads the next frame from the line. The frame is complete once the line has been silent for t3.5.
+func (t *rtuTransport) ReadRequest() (req *pdu, err error) {
+	var rxbuf = make([]byte, maxRTUFrameLength)
+	var n, m int
+
+	// wait for the first byte of the next frame
+	if err = t.link.SetReadDeadline(time.Time{}); err != nil {
+		return
+	}
+	if n, err = io.ReadAtLeast(t.link, rxbuf, 1); err != nil {
+		return
+	}
+
+	// read on until the line has been silent for t3.5
+	for {
+		if err = t.link.SetReadDeadline(time.Now().Add(t.t35)); err != nil {
+			return
+		}
+		if n == len(rxbuf) {
+			// oversized frame, skip it and everything else until silence
+			n = 0
+		}
+		m, err = t.link.Read(rxbuf[n:])
+		n += m
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+	}
+	t.lastActivity = time.Now()
+
+	return decodeRTUFrame(rxbuf[:n])
+}
+
+func (t *rtuTransport) EncodeResponse(res *pdu) []byte {
+	return assembleRTUFrame(res)
+}
+
+// WriteFrame sends the frame once the line has been silent for t3.5 after the last frame.
+func (t *rtuTransport) WriteFrame(adu []byte) (err error) {
+	time.Sleep(time.Until(t.lastActivity.Add(t.t35)))
+
+	_, err = t.link.Write(adu)
+	t.lastActivity = time.Now().Add(time.Duration(len(adu)) * t.charTime)
+	return
+}
+
+// rtuTCPTransport implements RTU framing on a TCP stream. As silence can't be detected reliably on a stream, request
+// frames are delimited by the length implied by their function code.
+type rtuTCPTransport struct {
+	sock        net.Conn
+	idleTimeout time.Duration // the client's idle timeout, 0 = never
+}
+
+// unknownFunctionSilence is the silence ending requests whose length can't be derived from their function code.
+const unknownFunctionSilence = 20 * time.Millisecond
+
+func (t *rtuTCPTransport) ReadRequest() (req *pdu, err error) {
+	// unit id + function code
+	adu := make([]byte, 0, maxRTUFrameLength)
+	if adu, err = readMore(t.sock, adu, 2); err != nil {
+		return
+	}
+
+	switch adu[1] {
+	case fcReadCoils, fcReadDiscreteInputs, fcReadHoldingRegisters, fcReadInputRegisters, fcWriteSingleCoil,
+		fcWriteSingleRegister:
+		adu, err = readMore(t.sock, adu, 4)
+	case fcWriteMultipleCoils, fcWriteMultipleRegisters:
+		// address, quantity, byte count, values
+		if adu, err = readMore(t.sock, adu, 5); err == nil {
+			adu, err = readMore(t.sock, adu, int(adu[6]))
+		}
+	case fcMaskWriteRegister:
+		adu, err = readMore(t.sock, adu, 6)
+	case fcReadWriteMultipleRegisters:
+		// read address, read quantity, write address, write quantity, byte count, values
+		if adu, err = readMore(t.sock, adu, 9); err == nil {
+			adu, err = readMore(t.sock, adu, int(adu[10]))
+		}
+	default:
+		// read whatever arrives until the stream is silent, including the CRC
+		var n int
+		for err == nil && len(adu) < cap(adu) {
+			_ = t.sock.SetReadDeadline(time.Now().Add(unknownFunctionSilence))
+			n, err = t.sock.Read(adu[len(adu):cap(adu)])
+			adu = adu[:len(adu)+n]
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			// the silence ends the frame, restore the idle timeout the silence deadline has replaced
+			var deadline time.Time
+			if t.idleTimeout > 0 {
+				deadline = time.Now().Add(t.idleTimeout)
+			}
+			err = t.sock.SetReadDeadline(deadline)
+		}
+		if err != nil {
+			return
+		}
+		return decodeRTUFrame(adu)
+	}
+	if err != nil {
+		return
+	}
+
+	// crc
+	if adu, err = readMore(t.sock, adu, 2); err != nil {
+		return
+	}
+	return decodeRTUFrame(adu)
+}
+
+func (t *rtuTCPTransport) EncodeResponse(res *pdu) []byte {
+	return assembleRTUFrame(res)
+}
+
+func (t *rtuTCPTransport) WriteFrame(adu []byte) (err error) {
+	_, err = t.sock.Write(adu)
+	return
+}
+
+// readMore reads exactly n more bytes from r and appends them to buf.
+func readMore(r io.Reader, buf []byte, n int) ([]byte, error) {
+	if len(buf)+n > cap(buf) {
+		return buf, ErrProtocolError
+	}
+	_, err := io.ReadFull(r, buf[len(buf):len(buf)+n])
+	return buf[:len(buf)+n], err
+}
+
+// decodeRTUFrame validates the frame's CRC and returns its PDU.
+func decodeRTUFrame(adu []byte) (p *pdu, err error) {
+	// unit id + function code + crc
+	if len(adu) < 4 {
+		err = ErrShortFrame
+		return
+	}
+
+	if crc16(adu[:len(adu)-2]) != uint16(adu[len(adu)-2])|uint16(adu[len(adu)-1])<<8 {
+		err = ErrBadCRC
+		return
+	}
+
+	p = &pdu{
+		unitId:       adu[0],
+		functionCode: adu[1],
+		payload:      adu[2 : len(adu)-2],
+	}
+	return
+}
+
+// assembleRTUFrame turns a PDU into an RTU frame (unit id + PDU + CRC).
+func assembleRTUFrame(p *pdu) (adu []byte) {
+	adu = append(adu, p.unitId, p.functionCode)
+	adu = append(adu, p.payload...)
+
+	// the CRC is sent low byte first
+	crc := crc16(adu)
+	adu = append(adu, byte(crc), byte(crc>>8))
+	return
+}
+
+// crc16 computes the modbus CRC16 (polynomial 0xA001, initial value 0xFFFF).
+func crc16(data []byte) uint16 {
+	var crc uint16 = 0xFFFF
+	for _, b := range data {
+		crc ^= uint16(b)
+		for range 8 {
+			if crc&0x0001 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// serveRTU answers the requests on the serial line until the line fails or the server is stopped. Frames with a bad
+// CRC and requests for unknown slaves are ignored, as other devices may share the bus.
+func (s *ModbusServer) serveRTU(t *rtuTransport) {
+	defer t.link.Close()
+
+	for {
+		req, err := t.ReadRequest()
+		switch {
+		case errors.Is(err, ErrBadCRC), errors.Is(err, ErrShortFrame):
+			ts := time.Now().Format(time.DateTime)
+			s.logger.Append(fmt.Sprintf("%s rtu: frame discarded: %v", ts, err))
+			continue
+		case err != nil:
+			if !s.stopping() {
+				slog.Error("failed to read from serial line", "url", s.serial.Url, "error", err)
+			}
+			return
+		}
+
+		if _, _, known := s.slaveState(int(req.unitId)); !known && req.unitId != 0 {
+			continue
+		}
+
+		s.loopLock.Lock()
+		if s.stopping() {
+			s.loopLock.Unlock()
+			return
+		}
+		adu, _ := s.serveRequest(req, "", t.EncodeResponse)
+		if adu != nil {
+			err = t.WriteFrame(adu)
+		}
+		s.loopLock.Unlock()
+		if err != nil {
+			slog.Error("failed to write to serial line", "url", s.serial.Url, "error", err)
+			return
+		}
+	}
+}
+
+// openRTULink opens the serial device or creates the pseudo-terminal named by the server's URL.
+func (s *ModbusServer) openRTULink() (rtuLink, error) {
+	if s.url == "pty" || strings.HasPrefix(s.url, "pty:") {
+		link, slavePath, err := openPTY(s.serial)
+		if err != nil {
+			return nil, err
+		}
+
+		// optionally provide a stable path for the masters' configuration
+		if linkPath, ok := strings.CutPrefix(s.url, "pty:"); ok {
+			if err = replaceSymlink(slavePath, linkPath); err != nil {
+				_ = link.Close()
+				return nil, err
+			}
+			link = &symlinkedPTY{rtuLink: link, path: linkPath, target: slavePath}
+			slavePath = linkPath
+		}
+
+		ts := time.Now().Format(time.DateTime)
+		s.logger.Append(fmt.Sprintf("%s rtu: serving on pseudo-terminal %s", ts, slavePath))
+		return link, nil
+	}
+
+	return openSerialPort(s.url, s.serial)
+}
+
+// replaceSymlink creates a symlink at path pointing to target. An existing symlink at path, e.g. left behind by a
+// crashed simulator, is replaced, anything else at path is never removed.
+func replaceSymlink(target, path string) error {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("rtu: %s exists and is not a symlink", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, path)
+}
+
+// symlinkedPTY is a pseudo-terminal with a symlink to its slave device. Closing it removes the symlink unless it has
+// been replaced in the meantime.
+type symlinkedPTY struct {
+	rtuLink
+	path   string
+	target string
+}
+
+func (l *symlinkedPTY) Close() error {
+	if target, err := os.Readlink(l.path); err == nil && target == l.target {
+		_ = os.Remove(l.path)
+	}
+	return l.rtuLink.Close()
+}
+
+// speed returns the configured baud rate, 19200 by default.
+func speed(serial modbus.Serial) int {
+	if serial.Speed == 0 {
+		return 19200
+	}
+	return serial.Speed
+}
+
+// serialCharTime returns how long it takes to send one character: 1 start bit, the data bits, the parity bit if
+// any and the stop bits.
+func serialCharTime(serial modbus.Serial) time.Duration {
+	dataBits := serial.DataBits
+	if dataBits == 0 {
+		dataBits = 8
+	}
+
+	bits := 1 + dataBits + serial.StopBits
+	if serial.Parity != parityNone {
+		bits++
+	}
+	if serial.StopBits == 0 {
+		// as in the client: 2 stop bits without parity, 1 otherwise
+		bits += 1
+		if serial.Parity == parityNone {
+			bits++
+		}
+	}
+	return time.Duration(bits) * time.Second / time.Duration(speed(serial))
+}
+
+// parity values of modbus.Serial.Parity
+const (
+	parityNone = 0
+	parityEven = 1
+	parityOdd  = 2
+)
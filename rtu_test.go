@@ -0,0 +1,152 @@
+package modsimpro
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// startServer starts a server for the configuration with slave 1 online, url's port should be 0.
+func startServer(t *testing.T, serial modbus.Serial) *ModbusServer {
+	t.Helper()
+	if len(serial.Slaves) == 0 {
+		serial.Slaves = []modbus.Slave{{Address: 1}}
+	}
+	s := NewModbusServer(serial, testLogger{t})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	for _, slave := range serial.Slaves {
+		s.Connect(int(slave.Address))
+	}
+	return s
+}
+
+// dial opens a raw connection to the server.
+func dial(t *testing.T, s *ModbusServer, network string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial(network, s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	return conn
+}
+
+// rtuFrame returns the RTU frame of a request: unit id, function code, payload and CRC.
+func rtuFrame(unitID uint8, functionCode uint8, payload ...byte) []byte {
+	return assembleRTUFrame(&pdu{unitId: unitID, functionCode: functionCode, payload: payload})
+}
+
+// expectFrame reads len(want) bytes from conn and compares them with want.
+func expectFrame(t *testing.T, conn net.Conn, want []byte) {
+	t.Helper()
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("want % X: %v", want, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got % X, want % X", got, want)
+	}
+}
+
+func TestRTUOverTCPShortFrame(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "rtuovertcp://127.0.0.1:0"})
+	s.MemoryMap(1).PutHoldingReg(0x10, 42)
+	conn := dial(t, s, "tcp")
+
+	// an unknown function code is read until the stream is silent, three bytes are too short for a frame
+	if _, err := conn.Write([]byte{0x01, 0x99, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * unknownFunctionSilence)
+
+	if _, err := conn.Write(rtuFrame(1, fcReadHoldingRegisters, 0x00, 0x10, 0x00, 0x01)); err != nil {
+		t.Fatal(err)
+	}
+	expectFrame(t, conn, rtuFrame(1, fcReadHoldingRegisters, 0x02, 0x00, 42))
+}
+
+func TestRTUOverTCPBadCRC(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "rtuovertcp://127.0.0.1:0"})
+	conn := dial(t, s, "tcp")
+
+	// the corrupted write is discarded, the connection stays open
+	bad := rtuFrame(1, fcWriteSingleRegister, 0x00, 0x10, 0x00, 0x07)
+	bad[len(bad)-1] ^= 0xFF
+	if _, err := conn.Write(bad); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write(rtuFrame(1, fcReadHoldingRegisters, 0x00, 0x10, 0x00, 0x01)); err != nil {
+		t.Fatal(err)
+	}
+	expectFrame(t, conn, rtuFrame(1, fcReadHoldingRegisters, 0x02, 0x00, 0x00))
+}
+
+func TestRTUOverTCPPipelinedRequests(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "rtuovertcp://127.0.0.1:0"})
+	conn := dial(t, s, "tcp")
+
+	// all requests arrive in a single write, the frames are delimited by their function codes
+	var requests []byte
+	for _, frame := range [][]byte{
+		rtuFrame(1, fcWriteMultipleRegisters, 0x00, 0x10, 0x00, 0x02, 0x04, 0x00, 0x01, 0x00, 0x02),
+		rtuFrame(1, fcWriteMultipleCoils, 0x00, 0x00, 0x00, 0x03, 0x01, 0x05),
+		rtuFrame(1, fcReadWriteMultipleRegisters, 0x00, 0x10, 0x00, 0x03, 0x00, 0x12, 0x00, 0x01, 0x02, 0x00, 0x03),
+		rtuFrame(1, fcMaskWriteRegister, 0x00, 0x12, 0x00, 0x00, 0x00, 0x09),
+		rtuFrame(1, fcReadCoils, 0x00, 0x00, 0x00, 0x03),
+		rtuFrame(1, fcReadHoldingRegisters, 0x00, 0x12, 0x00, 0x01),
+	} {
+		requests = append(requests, frame...)
+	}
+	if _, err := conn.Write(requests); err != nil {
+		t.Fatal(err)
+	}
+
+	expectFrame(t, conn, rtuFrame(1, fcWriteMultipleRegisters, 0x00, 0x10, 0x00, 0x02))
+	expectFrame(t, conn, rtuFrame(1, fcWriteMultipleCoils, 0x00, 0x00, 0x00, 0x03))
+	expectFrame(t, conn, rtuFrame(1, fcReadWriteMultipleRegisters, 0x06, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03))
+	expectFrame(t, conn, rtuFrame(1, fcMaskWriteRegister, 0x00, 0x12, 0x00, 0x00, 0x00, 0x09))
+	expectFrame(t, conn, rtuFrame(1, fcReadCoils, 0x01, 0x05))
+	expectFrame(t, conn, rtuFrame(1, fcReadHoldingRegisters, 0x02, 0x00, 0x09))
+}
+
+func TestRTUBadCRC(t *testing.T) {
+	serial := modbus.Serial{Url: "rtu:///dev/null", Slaves: []modbus.Slave{{Address: 1}}}
+	s := NewModbusServer(serial, testLogger{t})
+	s.Connect(1)
+	s.MemoryMap(1).PutHoldingReg(0x10, 42)
+
+	line, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.serveRTU(newRTUTransport(line, serial))
+		close(done)
+	}()
+	defer func() {
+		_ = conn.Close()
+		<-done
+	}()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// frames with a bad crc and requests for other slaves are ignored, the silence between the frames ends them
+	bad := rtuFrame(1, fcWriteSingleRegister, 0x00, 0x10, 0x00, 0x07)
+	bad[len(bad)-2] ^= 0xFF
+	for _, frame := range [][]byte{bad, rtuFrame(2, fcReadHoldingRegisters, 0x00, 0x10, 0x00, 0x01)} {
+		if _, err := conn.Write(frame); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := conn.Write(rtuFrame(1, fcReadHoldingRegisters, 0x00, 0x10, 0x00, 0x01)); err != nil {
+		t.Fatal(err)
+	}
+	expectFrame(t, conn, rtuFrame(1, fcReadHoldingRegisters, 0x02, 0x00, 42))
+}
@@ -0,0 +1,333 @@
+package scenario
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rwirdemann/modsimpro"
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// pollInterval is the interval at which wait steps check their condition.
+const pollInterval = 50 * time.Millisecond
+
+// errTimeout ends a scenario whose wait step has timed out.
+var errTimeout = errors.New("timeout")
+
+// stepError is the error of a step that has already been recorded as the step's result.
+type stepError struct {
+	error
+}
+
+func (e stepError) Unwrap() error {
+	return e.error
+}
+
+// Result is the outcome of a wait, wait_write or assert step, or of an action that failed.
+type Result struct {
+	Step    string `json:"step"`
+	Action  string `json:"action"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// Report is the outcome of a scenario run.
+type Report struct {
+	Name    string   `json:"name"`
+	Passed  bool     `json:"passed"`
+	Results []Result `json:"results"`
+}
+
+// String returns a one line summary of the report.
+func (r Report) String() string {
+	failed := 0
+	for _, result := range r.Results {
+		if !result.Passed {
+			failed++
+		}
+	}
+	status := "passed"
+	if !r.Passed {
+		status = "failed"
+	}
+	return fmt.Sprintf("scenario %q %s: %d checks, %d failed", r.Name, status, len(r.Results), failed)
+}
+
+// write is an executed write request received by the server with the given index.
+type write struct {
+	server int
+	event  modsimpro.Event
+}
+
+// maxWrites limits the number of recorded writes not yet consumed by a wait_write step, the oldest are dropped.
+const maxWrites = 10000
+
+// Runner plays scenarios against a set of running servers.
+type Runner struct {
+	servers []*modsimpro.ModbusServer
+	logger  modsimpro.Logger
+	lock    sync.Mutex
+	writes  []write // writes not yet consumed by a wait_write step
+}
+
+// NewRunner creates a runner for the servers and starts recording the write requests they receive. Progress is
+// appended to logger.
+func NewRunner(servers []*modsimpro.ModbusServer, logger modsimpro.Logger) *Runner {
+	r := &Runner{servers: servers, logger: logger}
+	for i, s := range servers {
+		s.Subscribe(func(e modsimpro.Event) {
+			if e.IsWrite() && e.Executed {
+				r.lock.Lock()
+				r.writes = append(r.writes, write{server: i, event: e})
+				if len(r.writes) > maxWrites {
+					r.writes = slices.Delete(r.writes, 0, len(r.writes)-maxWrites)
+				}
+				r.lock.Unlock()
+			}
+		})
+	}
+	return r
+}
+
+// add appends the result of a step to the report. Inside a loop running forever the step's earlier result is
+// replaced instead, unless it has failed, so that the report doesn't grow without bound.
+func (r *Report) add(result Result, forever bool) {
+	if forever {
+		for i := len(r.Results) - 1; i >= 0; i-- {
+			if r.Results[i].Step == result.Step {
+				if r.Results[i].Passed {
+					r.Results[i] = result
+				}
+				return
+			}
+		}
+	}
+	r.Results = append(r.Results, result)
+}
+
+// Run plays the scenario until its last step has completed, a wait step has timed out or ctx is cancelled.
+func (r *Runner) Run(ctx context.Context, sc *Scenario) Report {
+	report := Report{Name: sc.Name, Passed: true}
+	r.log("scenario %q started", sc.Name)
+
+	if err := r.run(ctx, sc.Steps, "", false, &report); err != nil {
+		report.Passed = false
+		// errors of steps are in the results already
+		if !errors.As(err, new(stepError)) {
+			report.Results = append(report.Results, Result{Action: "run", Message: err.Error()})
+		}
+	}
+	for _, result := range report.Results {
+		report.Passed = report.Passed && result.Passed
+	}
+
+	r.log("%s", report)
+	return report
+}
+
+// run executes the steps with their at times relative to now. prefix is the position of the enclosing loop, forever
+// whether a loop running forever encloses the steps.
+func (r *Runner) run(ctx context.Context, steps []Step, prefix string, forever bool, report *Report) error {
+	start := time.Now()
+	for i, step := range steps {
+		pos := position(prefix, i)
+		if err := sleep(ctx, time.Until(start.Add(time.Duration(step.At)*time.Millisecond))); err != nil {
+			return err
+		}
+
+		if step.Action == "loop" {
+			for n := 0; step.Count == 0 || n < step.Count; n++ {
+				if err := r.run(ctx, step.Steps, pos, forever || step.Count == 0, report); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		result, err := r.execute(ctx, step)
+		if result != nil || err != nil {
+			if result == nil {
+				result = &Result{Message: err.Error()}
+			}
+			result.Step, result.Action = pos, step.Action
+			if step.Message != "" {
+				result.Message = step.Message + ": " + result.Message
+			}
+			report.add(*result, forever)
+
+			status := "passed"
+			if !result.Passed {
+				status = "failed"
+			}
+			r.log("step %s %s %s: %s", pos, step.Action, status, result.Message)
+		}
+		if err != nil {
+			return stepError{err}
+		}
+	}
+	return nil
+}
+
+// execute performs a single step. Checks return their result, actions only an error if they failed.
+func (r *Runner) execute(ctx context.Context, step Step) (*Result, error) {
+	if step.Server < 0 || step.Server >= len(r.servers) {
+		return nil, fmt.Errorf("unknown server: %d", step.Server)
+	}
+	s := r.servers[step.Server]
+
+	mm := s.MemoryMap(step.Slave)
+	if mm == nil && step.Action != "connect" && step.Action != "sleep" {
+		return nil, fmt.Errorf("unknown slave: %d", step.Slave)
+	}
+
+	switch step.Action {
+	case "connect":
+		s.Connect(step.Slave)
+	case "disconnect":
+		s.Disconnect(step.Slave)
+	case "write":
+		return nil, mm.WriteValue(step.registerType(), step.Address, step.datatype(), *step.Value)
+	case "generator":
+		return nil, s.AddGenerator(step.Slave, *step.Generator)
+	case "remove_generator":
+		return nil, s.RemoveGenerator(step.Slave, step.Index)
+	case "fault":
+		_, err := s.AddFault(step.Slave, *step.Fault)
+		return nil, err
+	case "remove_fault":
+		return nil, s.RemoveFault(step.Slave, step.Index)
+	case "fault_on", "fault_off":
+		faults := s.Faults(step.Slave)
+		if step.Index < 0 || step.Index >= len(faults) {
+			return nil, fmt.Errorf("unknown fault: %d", step.Index)
+		}
+		faults[step.Index].SetEnabled(step.Action == "fault_on")
+	case "sleep":
+		return nil, sleep(ctx, time.Duration(step.Duration)*time.Millisecond)
+	case "assert":
+		ok, v, err := check(mm, step)
+		if err != nil {
+			return &Result{Message: err.Error()}, nil
+		}
+		return &Result{Passed: ok, Message: describe(step, v)}, nil
+	case "wait":
+		return r.wait(ctx, step, func() (bool, string) {
+			ok, v, err := check(mm, step)
+			if err != nil {
+				return false, err.Error()
+			}
+			return ok, describe(step, v)
+		})
+	case "wait_write":
+		return r.wait(ctx, step, func() (bool, string) {
+			return r.consumeWrite(step)
+		})
+	}
+	return nil, nil
+}
+
+// wait polls cond until it holds or the step's timeout has expired, which fails the step and ends the scenario.
+func (r *Runner) wait(ctx context.Context, step Step, cond func() (bool, string)) (*Result, error) {
+	deadline := time.Now().Add(time.Duration(step.Timeout) * time.Millisecond)
+	for {
+		ok, message := cond()
+		if ok {
+			return &Result{Passed: true, Message: message}, nil
+		}
+		if time.Now().After(deadline) {
+			return &Result{Message: fmt.Sprintf("timed out after %d ms: %s", step.Timeout, message)}, errTimeout
+		}
+		if err := sleep(ctx, pollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// consumeWrite looks for the first unconsumed write of the step's register that matches its value. The write and
+// all writes before it are consumed.
+func (r *Runner) consumeWrite(step Step) (bool, string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	coils := step.registerType() == "coil"
+	n, err := modbus.RegisterCount(step.datatype())
+	if err != nil {
+		return false, err.Error()
+	}
+	if coils {
+		n = 1
+	}
+
+	for i := 0; i < len(r.writes); i++ {
+		w := r.writes[i]
+		e := w.event
+		isCoilWrite := e.FunctionCode == 0x05 || e.FunctionCode == 0x0F
+		if w.server != step.Server || int(e.SlaveID) != step.Slave || isCoilWrite != coils || e.FunctionCode == 0x16 {
+			continue
+		}
+		if step.Address < e.Address || int(step.Address)+n > int(e.Address)+len(e.Values) {
+			continue
+		}
+
+		offset := int(step.Address - e.Address)
+		v, err := modbus.DecodeFloat(step.datatype(), e.Values[offset:offset+n])
+		if coils {
+			v, err = float64(e.Values[offset]), nil
+		}
+		if err != nil {
+			continue
+		}
+		if step.Value != nil {
+			if ok, _ := compare(step.Op, v, *step.Value); !ok {
+				continue
+			}
+		}
+		r.writes = slices.Delete(r.writes, 0, i+1)
+		return true, fmt.Sprintf("slave %d wrote %v to %s 0x%X", step.Slave, v, step.registerType(), step.Address)
+	}
+	return false, fmt.Sprintf("no matching write to %s 0x%X", step.registerType(), step.Address)
+}
+
+// check compares the current value of the step's register with the step's value.
+func check(mm *modbus.MemoryMap, step Step) (bool, float64, error) {
+	v, err := mm.ReadValue(step.registerType(), step.Address, step.datatype())
+	if err != nil {
+		return false, 0, err
+	}
+	ok, err := compare(step.Op, v, *step.Value)
+	return ok, v, err
+}
+
+// describe returns the check of the step together with the actual value, e.g. "holding 0x64 = 12, want >= 15".
+func describe(step Step, v float64) string {
+	op := step.Op
+	if op == "" {
+		op = "=="
+	}
+	return fmt.Sprintf("%s 0x%X = %v, want %s %v", step.registerType(), step.Address, v, op, *step.Value)
+}
+
+func (r *Runner) log(format string, args ...any) {
+	ts := time.Now().Format(time.DateTime)
+	r.logger.Append(fmt.Sprintf("%s scenario: %s", ts, strings.TrimSpace(fmt.Sprintf(format, args...))))
+}
+
+// sleep pauses for d or until ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,68 @@
+package scenario
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rwirdemann/modsimpro"
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+type discardLogger struct{}
+
+func (discardLogger) Append(string) {}
+
+func newRunner(t *testing.T) *Runner {
+	t.Helper()
+	s := modsimpro.NewModbusServer(modbus.Serial{Url: "tcp://127.0.0.1:0"}, discardLogger{})
+	s.Connect(1)
+	return NewRunner([]*modsimpro.ModbusServer{s}, discardLogger{})
+}
+
+func TestRunReportsFailedStepOnce(t *testing.T) {
+	one := 1.0
+	tests := []struct {
+		name string
+		step Step
+		want string
+	}{
+		{"failed action", Step{Action: "fault_on", Slave: 1, Index: 3}, "unknown fault: 3"},
+		{"unknown slave", Step{Action: "write", Slave: 2, Value: &one}, "unknown slave: 2"},
+		{"timed out wait", Step{Action: "wait", Slave: 1, Value: &one, Timeout: 10}, "timed out after 10 ms"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := &Scenario{Steps: []Step{
+				{Action: "loop", Count: 1, Steps: []Step{tt.step}},
+				{Action: "connect", Slave: 1},
+			}}
+			report := newRunner(t).Run(context.Background(), sc)
+			if report.Passed || len(report.Results) != 1 {
+				t.Fatalf("got %+v, want a single failed result", report)
+			}
+			if r := report.Results[0]; r.Step != "1.1" || r.Action != tt.step.Action || !strings.HasPrefix(r.Message, tt.want) {
+				t.Errorf("got %+v, want step 1.1 %s: %s", r, tt.step.Action, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunReportsCancellation(t *testing.T) {
+	zero := 0.0
+	sc := &Scenario{Steps: []Step{
+		{Action: "assert", Slave: 1, Value: &zero},
+		{Action: "assert", At: 10000, Slave: 1, Value: &zero},
+	}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	report := newRunner(t).Run(ctx, sc)
+	if report.Passed || len(report.Results) != 2 {
+		t.Fatalf("got %+v, want the passed assertion and the cancellation", report)
+	}
+	if r := report.Results[1]; r.Action != "run" || r.Message != context.DeadlineExceeded.Error() {
+		t.Errorf("got %+v", r)
+	}
+}
@@ -0,0 +1,189 @@
+// Package scenario plays timed test stories against running simulators. A scenario is a JSON file with a list of
+// steps executed in order:
+//
+//	{
+//	  "name": "battery runs low",
+//	  "steps": [
+//	    {"action": "connect", "slave": 101},
+//	    {"at": 30000, "action": "write", "slave": 101, "register_type": "input", "address": 100, "datatype": "F32T1234", "value": 15},
+//	    {"action": "wait_write", "slave": 101, "address": 2019, "value": 1, "timeout": 10000},
+//	    {"at": 60000, "action": "disconnect", "slave": 102}
+//	  ]
+//	}
+//
+// Actions:
+//
+//	connect, disconnect           bring the slave online or take it offline
+//	write                         write value encoded as datatype to the register
+//	generator, remove_generator   attach generator to the slave, remove the slave's generator with index
+//	fault, remove_fault           inject fault into the slave's responses, remove the slave's fault with index
+//	fault_on, fault_off           switch the slave's fault with index on or off
+//	sleep                         pause for duration ms
+//	wait                          wait up to timeout ms until the register's value compares to value with op
+//	wait_write                    wait up to timeout ms until the master writes value (any value if omitted) to the
+//	                              register, writes already consumed by an earlier wait_write don't count
+//	assert                        check that the register's value compares to value with op
+//	loop                          run steps count times, forever if count is 0, which needs a step with at or a sleep
+//
+// A step with at starts no earlier than at ms after the start of the scenario or, inside a loop, of the loop's
+// iteration. server selects the server by its index in the configuration, the first one by default. Registers are
+// holding registers of datatype U16 unless register_type and datatype say otherwise, op defaults to ==. Failed
+// assertions are reported and the scenario goes on, a wait that times out ends the scenario. Of the steps of a loop
+// running forever only the latest result is reported, or the first failure.
+package scenario
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// Scenario is a named sequence of steps.
+type Scenario struct {
+	Name  string `json:"name"`
+	Steps []Step `json:"steps"`
+}
+
+// Step is a single action of a scenario. Which of the fields are used depends on the action.
+type Step struct {
+	Action       string                  `json:"action"`
+	At           int                     `json:"at,omitempty"` // ms
+	Server       int                     `json:"server,omitempty"`
+	Slave        int                     `json:"slave,omitempty"`
+	RegisterType string                  `json:"register_type,omitempty"`
+	Address      uint16                  `json:"address,omitempty"`
+	Datatype     string                  `json:"datatype,omitempty"`
+	Value        *float64                `json:"value,omitempty"`
+	Op           string                  `json:"op,omitempty"`       // ==, !=, <, <=, >, >=
+	Duration     int                     `json:"duration,omitempty"` // ms
+	Timeout      int                     `json:"timeout,omitempty"`  // ms
+	Generator    *modbus.GeneratorConfig `json:"generator,omitempty"`
+	Fault        *modbus.FaultConfig     `json:"fault,omitempty"`
+	Index        int                     `json:"index,omitempty"`
+	Count        int                     `json:"count,omitempty"`
+	Steps        []Step                  `json:"steps,omitempty"`
+	Message      string                  `json:"message,omitempty"` // describes an assertion or wait in the report
+}
+
+// Load reads and validates the scenario file.
+func Load(path string) (*Scenario, error) {
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sc Scenario
+	decoder := json.NewDecoder(bytes.NewReader(bb))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&sc); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := validate(sc.Steps, ""); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &sc, nil
+}
+
+// validate checks the steps for missing or invalid fields. prefix is the position of the enclosing loop.
+func validate(steps []Step, prefix string) error {
+	for i, step := range steps {
+		pos := position(prefix, i)
+		var err error
+		switch step.Action {
+		case "connect", "disconnect", "remove_generator", "remove_fault", "fault_on", "fault_off":
+		case "write":
+			if step.Value == nil {
+				err = fmt.Errorf("missing value")
+			}
+		case "generator":
+			if step.Generator == nil {
+				err = fmt.Errorf("missing generator")
+			}
+		case "fault":
+			if step.Fault == nil {
+				err = fmt.Errorf("missing fault")
+			}
+		case "sleep":
+		case "wait", "assert":
+			if step.Value == nil {
+				err = fmt.Errorf("missing value")
+			} else if _, err = compare(step.Op, 0, 0); err == nil && step.Action == "wait" && step.Timeout <= 0 {
+				err = fmt.Errorf("timeout must be > 0")
+			}
+		case "wait_write":
+			if step.Timeout <= 0 {
+				err = fmt.Errorf("timeout must be > 0")
+			}
+		case "loop":
+			switch {
+			case step.Count < 0:
+				err = fmt.Errorf("count must be >= 0")
+			case step.Count == 0 && !takesTime(step.Steps):
+				err = fmt.Errorf("a loop with count 0 needs a step with at > 0 or a sleep")
+			default:
+				err = validate(step.Steps, pos)
+			}
+		default:
+			err = fmt.Errorf("unknown action: %s", step.Action)
+		}
+		if err != nil {
+			return fmt.Errorf("step %s: %w", pos, err)
+		}
+	}
+	return nil
+}
+
+// takesTime reports whether an iteration of steps lasts for some time, because of a step with at > 0 or a sleep.
+func takesTime(steps []Step) bool {
+	for _, step := range steps {
+		if step.At > 0 || step.Action == "sleep" && step.Duration > 0 || step.Action == "loop" && takesTime(step.Steps) {
+			return true
+		}
+	}
+	return false
+}
+
+// position returns the 1-based position of a step, e.g. 3.2 for the second step of the loop at step 3.
+func position(prefix string, i int) string {
+	if prefix == "" {
+		return fmt.Sprintf("%d", i+1)
+	}
+	return fmt.Sprintf("%s.%d", prefix, i+1)
+}
+
+func (s Step) registerType() string {
+	if s.RegisterType == "" {
+		return "holding"
+	}
+	return s.RegisterType
+}
+
+func (s Step) datatype() string {
+	if s.Datatype == "" {
+		return "U16"
+	}
+	return s.Datatype
+}
+
+// compare applies the comparison operator op, == if empty.
+func compare(op string, a float64, b float64) (bool, error) {
+	switch op {
+	case "", "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	case ">=":
+		return a >= b, nil
+	default:
+		return false, fmt.Errorf("unknown operator: %s", op)
+	}
+}
@@ -0,0 +1,148 @@
+//go:build linux
+
+package modsimpro
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+	"golang.org/x/sys/unix"
+)
+
+var baudRates = map[int]uint32{
+	1200:   unix.B1200,
+	2400:   unix.B2400,
+	4800:   unix.B4800,
+	9600:   unix.B9600,
+	19200:  unix.B19200,
+	38400:  unix.B38400,
+	57600:  unix.B57600,
+	115200: unix.B115200,
+	230400: unix.B230400,
+}
+
+// ptyLink is the master side of a pseudo-terminal. The slave side is kept open, so that reads don't fail while no
+// master application has opened it.
+type ptyLink struct {
+	*os.File
+	slave *os.File
+}
+
+func (l *ptyLink) Close() error {
+	_ = l.slave.Close()
+	return l.File.Close()
+}
+
+// openSerialPort opens the serial device in raw mode with the configured line settings.
+func openSerialPort(device string, serial modbus.Serial) (rtuLink, error) {
+	f, err := os.OpenFile(device, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = configureTTY(f, serial); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("%s: %w", device, err)
+	}
+	return f, nil
+}
+
+// openPTY creates a pseudo-terminal pair and returns its master side together with the path of the slave device
+// masters can connect to.
+func openPTY(serial modbus.Serial) (rtuLink, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var ptn uint32
+	err = control(master, func(fd int) error {
+		if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+			return err
+		}
+		ptn, err = unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+		return err
+	})
+	if err != nil {
+		_ = master.Close()
+		return nil, "", fmt.Errorf("pty: %w", err)
+	}
+
+	slavePath := fmt.Sprintf("/dev/pts/%d", ptn)
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		_ = master.Close()
+		return nil, "", err
+	}
+	if err = configureTTY(slave, serial); err != nil {
+		_ = slave.Close()
+		_ = master.Close()
+		return nil, "", fmt.Errorf("%s: %w", slavePath, err)
+	}
+
+	return &ptyLink{File: master, slave: slave}, slavePath, nil
+}
+
+// configureTTY puts the terminal into raw mode with the configured speed, data bits, parity and stop bits.
+func configureTTY(f *os.File, serial modbus.Serial) error {
+	baud, ok := baudRates[speed(serial)]
+	if !ok {
+		return fmt.Errorf("unsupported speed: %d", serial.Speed)
+	}
+
+	return control(f, func(fd int) error {
+		t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			return err
+		}
+
+		t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF | unix.IXANY
+		t.Oflag &^= unix.OPOST
+		t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		t.Cflag &^= unix.CSIZE | unix.PARENB | unix.PARODD | unix.CSTOPB | unix.CBAUD
+		t.Cflag |= unix.CREAD | unix.CLOCAL | baud
+
+		switch serial.DataBits {
+		case 5:
+			t.Cflag |= unix.CS5
+		case 6:
+			t.Cflag |= unix.CS6
+		case 7:
+			t.Cflag |= unix.CS7
+		default:
+			t.Cflag |= unix.CS8
+		}
+
+		switch serial.Parity {
+		case parityEven:
+			t.Cflag |= unix.PARENB
+		case parityOdd:
+			t.Cflag |= unix.PARENB | unix.PARODD
+		}
+
+		if serial.StopBits == 2 || (serial.StopBits == 0 && serial.Parity == parityNone) {
+			t.Cflag |= unix.CSTOPB
+		}
+
+		t.Ispeed = baud
+		t.Ospeed = baud
+		t.Cc[unix.VMIN] = 1
+		t.Cc[unix.VTIME] = 0
+		return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+	})
+}
+
+// control runs fn on the file's descriptor without switching the file to blocking mode, which would disable read
+// deadlines.
+func control(f *os.File, fn func(fd int) error) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var fnErr error
+	if err = rc.Control(func(fd uintptr) { fnErr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return fnErr
+}
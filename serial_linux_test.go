@@ -0,0 +1,65 @@
+//go:build linux
+
+package modsimpro
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+	sv "github.com/simonvetter/modbus"
+)
+
+func TestPTYSymlink(t *testing.T) {
+	linkPath := filepath.Join(t.TempDir(), "ttyModsimpro")
+	// a symlink left behind by a crashed simulator is replaced
+	if err := os.Symlink("/dev/pts/999999", linkPath); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewModbusServer(modbus.Serial{Url: "rtu://pty:" + linkPath, Slaves: []modbus.Slave{{Address: 1}}}, testLogger{t})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.Connect(1)
+	s.MemoryMap(1).PutHoldingReg(0x10, 42)
+
+	client, err := sv.NewClient(&sv.ClientConfiguration{URL: "rtu://" + linkPath, Speed: 19200, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetUnitId(1)
+	v, err := client.ReadRegister(0x10, sv.HOLDING_REGISTER)
+	_ = client.Close()
+	if err != nil || v != 42 {
+		t.Errorf("got %d, %v, want 42", v, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(linkPath); !os.IsNotExist(err) {
+		t.Errorf("symlink not removed on close: %v", err)
+	}
+}
+
+func TestPTYSymlinkKeepsFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ttyUSB0")
+	if err := os.WriteFile(path, []byte("keep"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewModbusServer(modbus.Serial{Url: "rtu://pty:" + path}, testLogger{t})
+	defer s.Close()
+	if err := s.Start(); err == nil {
+		t.Fatal("started on a path that is not a symlink")
+	}
+	if bb, err := os.ReadFile(path); err != nil || string(bb) != "keep" {
+		t.Errorf("file changed: %q, %v", bb, err)
+	}
+}
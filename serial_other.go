@@ -0,0 +1,19 @@
+//go:build !linux
+
+package modsimpro
+
+import (
+	"errors"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+var errSerialUnsupported = errors.New("rtu server mode is only supported on linux")
+
+func openSerialPort(string, modbus.Serial) (rtuLink, error) {
+	return nil, errSerialUnsupported
+}
+
+func openPTY(modbus.Serial) (rtuLink, string, error) {
+	return nil, "", errSerialUnsupported
+}
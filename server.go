@@ -1,13 +1,19 @@
 package modsimpro
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"math/rand"
 	"net"
+	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rwirdemann/modsimpro/modbus"
@@ -17,79 +23,457 @@ type Logger interface {
 	Append(text string)
 }
 
-// ModbusServer represents a TCP based modbus server with multiple slaves connected to it.
+// ModbusServer represents a modbus server with multiple slaves connected to it. The scheme of its URL selects the
+// transport:
+//
+//	tcp://host:port         modbus TCP (MBAP framing)
+//	rtuovertcp://host:port  RTU frames with CRC on a TCP socket
+//	tcp+tls://host:port     modbus/TCP security: modbus TCP over mutually authenticated TLS
+//	udp://host:port         modbus TCP framing in UDP datagrams
+//	rtu:///dev/ttyUSB0      RTU slave on a serial device
+//	rtu://pty[:/link/path]  RTU slave on a newly created pseudo-terminal
+//
+// All transports share the same request handling.
 type ModbusServer struct {
-	url         string
-	logger      Logger
-	tcpListener net.Listener
-	sock        net.Conn
-	lastTxnId   uint16
-	slaves      map[int]bool
-	memoryMap   *modbus.MemoryMap
+	scheme          string
+	url             string
+	serial          modbus.Serial
+	logger          Logger
+	maxClients      int
+	idleTimeout     time.Duration
+	offlineResponse string
+	unmappedPolicy  modbus.UnmappedPolicy
+	tcpListener     net.Listener
+	udpConn         net.PacketConn
+	rtuLink         rtuLink
+	loopLock        sync.Mutex    // held while the udp or rtu loop handles a request
+	done            chan struct{} // closed when the server is stopped
+	wg              sync.WaitGroup
+	lock            sync.RWMutex
+	clients         map[*clientSession]struct{}
+	slaves          map[int]bool
+	memoryMaps      map[int]*modbus.MemoryMap
+	generators      []*generatorTask
+	clocks          []*clockTask
+	faults          []*faultTask
+	behaviours      map[int]*modbus.Behaviour
+	registers       map[int][]modbus.Register
+	subscribers     []func(Event)
 }
 
-func NewModbusServer(url string, logger Logger) *ModbusServer {
-	splitURL := strings.SplitN(url, "://", 2)
+// clientSession holds the state of a single accepted client connection.
+type clientSession struct {
+	sock      net.Conn
+	transport transport
+	role      string     // the role of a tcp+tls client's certificate
+	busy      sync.Mutex // held while a request of the client is handled
+}
+
+func NewModbusServer(serial modbus.Serial, logger Logger) *ModbusServer {
+	splitURL := strings.SplitN(serial.Url, "://", 2)
 	if len(splitURL) == 2 {
-		return &ModbusServer{url: splitURL[1], logger: logger,
-			slaves:    make(map[int]bool),
-			memoryMap: modbus.NewMemoryMap(),
+		policy, err := modbus.ParseUnmappedPolicy(serial.Unmapped)
+		if err != nil {
+			slog.Warn("invalid unmapped policy, falling back to default", "url", serial.Url, "error", err)
+		}
+
+		s := &ModbusServer{scheme: splitURL[0], url: splitURL[1], serial: serial, logger: logger,
+			maxClients:      serial.MaxClients,
+			idleTimeout:     time.Duration(serial.IdleTimeout) * time.Millisecond,
+			offlineResponse: serial.OfflineResponse,
+			unmappedPolicy:  policy,
+			done:            make(chan struct{}),
+			clients:         make(map[*clientSession]struct{}),
+			slaves:          make(map[int]bool),
+			memoryMaps:      make(map[int]*modbus.MemoryMap),
+			behaviours:      make(map[int]*modbus.Behaviour),
+			registers:       make(map[int][]modbus.Register),
+		}
+
+		// configured slaves are known but offline until they get connected, each of them has its own memory map
+		for _, slave := range serial.Slaves {
+			s.slaves[int(slave.Address)] = false
+			s.memoryMaps[int(slave.Address)] = s.newMemoryMap()
+			for _, config := range slave.Generators {
+				if err := s.AddGenerator(int(slave.Address), config); err != nil {
+					slog.Warn("invalid generator config", "url", serial.Url, "slave", slave.Address, "error", err)
+				}
+			}
+			for _, config := range slave.Clocks {
+				if err := s.addClock(int(slave.Address), config); err != nil {
+					slog.Warn("invalid clock config", "url", serial.Url, "slave", slave.Address, "error", err)
+				}
+			}
+			for _, config := range slave.Faults {
+				if _, err := s.AddFault(int(slave.Address), config); err != nil {
+					slog.Warn("invalid fault config", "url", serial.Url, "slave", slave.Address, "error", err)
+				}
+			}
 		}
+		return s
 	}
 	return nil
 }
 
+// Start opens the server's listener, socket or serial line and serves requests in the background until the server
+// is stopped. A stopped server can't be started again.
 func (s *ModbusServer) Start() (err error) {
-	s.tcpListener, err = net.Listen("tcp", s.url)
+	if s.stopping() {
+		return ErrServerClosed
+	}
+
+	switch s.scheme {
+	case "rtu":
+		s.rtuLink, err = s.openRTULink()
+		if err == nil {
+			s.goServe(func() { s.serveRTU(newRTUTransport(s.rtuLink, s.serial)) })
+		}
+	case "tcp", "rtuovertcp":
+		s.tcpListener, err = net.Listen("tcp", s.url)
+		if err == nil {
+			s.goServe(s.acceptTCPClients)
+		}
+	case "tcp+tls":
+		var config *tls.Config
+		if config, err = serverTLSConfig(s.serial); err != nil {
+			return
+		}
+		s.tcpListener, err = tls.Listen("tcp", s.url, config)
+		if err == nil {
+			s.goServe(s.acceptTCPClients)
+		}
+	case "udp":
+		s.udpConn, err = net.ListenPacket("udp", s.url)
+		if err == nil {
+			s.goServe(s.serveUDP)
+		}
+	default:
+		err = fmt.Errorf("unsupported scheme: %s", s.scheme)
+	}
+
 	if err == nil {
-		go s.acceptTCPClients()
+		s.goServe(s.runGenerators)
+		s.goServe(s.runBehaviours)
 	}
 
 	return
 }
 
+// Addr returns the address the server listens on, nil if it doesn't listen on a network address. With port 0 in its
+// URL this is the port actually chosen.
+func (s *ModbusServer) Addr() net.Addr {
+	switch {
+	case s.tcpListener != nil:
+		return s.tcpListener.Addr()
+	case s.udpConn != nil:
+		return s.udpConn.LocalAddr()
+	default:
+		return nil
+	}
+}
+
+// Serve starts the server and stops it once ctx is cancelled. It returns when the server has been stopped.
+func (s *ModbusServer) Serve(ctx context.Context) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return s.Close()
+}
+
+// Stop stops the server: it closes the listener, lets requests in progress complete, closes all client connections
+// and the serial line and waits for all of the server's goroutines to finish. If ctx expires first, connections are
+// closed without waiting for requests in progress and ctx's error is returned.
+func (s *ModbusServer) Stop(ctx context.Context) error {
+	s.lock.Lock()
+	if s.stopping() {
+		s.lock.Unlock()
+		return nil
+	}
+	close(s.done)
+	sessions := make([]*clientSession, 0, len(s.clients))
+	for session := range s.clients {
+		sessions = append(sessions, session)
+	}
+	s.lock.Unlock()
+
+	if s.tcpListener != nil {
+		_ = s.tcpListener.Close()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		// close the connections between two requests
+		for _, session := range sessions {
+			session.busy.Lock()
+			_ = session.sock.Close()
+			session.busy.Unlock()
+		}
+		s.loopLock.Lock()
+		s.closeLoopConns()
+		s.loopLock.Unlock()
+
+		s.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		for _, session := range sessions {
+			_ = session.sock.Close()
+		}
+		s.closeLoopConns()
+		return ctx.Err()
+	}
+}
+
+// Close stops the server and waits for requests in progress to complete.
+func (s *ModbusServer) Close() error {
+	return s.Stop(context.Background())
+}
+
+// closeLoopConns closes the udp socket and the serial line.
+func (s *ModbusServer) closeLoopConns() {
+	if s.udpConn != nil {
+		_ = s.udpConn.Close()
+	}
+	if s.rtuLink != nil {
+		_ = s.rtuLink.Close()
+	}
+}
+
+// stopping reports whether the server has been stopped.
+func (s *ModbusServer) stopping() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// goServe runs fn in a goroutine Stop waits for.
+func (s *ModbusServer) goServe(fn func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn()
+	}()
+}
+
 func (s *ModbusServer) Connect(slaveID int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.slaves[slaveID] = true
+	if _, ok := s.memoryMaps[slaveID]; !ok {
+		s.memoryMaps[slaveID] = s.newMemoryMap()
+	}
+}
+
+// newMemoryMap creates a memory map that answers reads of unmapped addresses according to the server's policy.
+func (s *ModbusServer) newMemoryMap() *modbus.MemoryMap {
+	mm := modbus.NewMemoryMap()
+	if s.unmappedPolicy != "" {
+		mm.SetUnmappedPolicy(s.unmappedPolicy)
+	}
+	return mm
 }
 
 func (s *ModbusServer) Disconnect(slaveID int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.slaves[slaveID] = false
 }
 
+// Online reports whether the slave is online.
+func (s *ModbusServer) Online(slaveID int) bool {
+	_, online, _ := s.slaveState(slaveID)
+	return online
+}
+
+// SlaveStatus is the state of a slave known to the server.
+type SlaveStatus struct {
+	ID     int  `json:"id"`
+	Online bool `json:"online"`
+}
+
+// Slaves returns the state of all slaves known to the server, ordered by id.
+func (s *ModbusServer) Slaves() (slaves []SlaveStatus) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for id, online := range s.slaves {
+		slaves = append(slaves, SlaveStatus{ID: id, Online: online})
+	}
+	slices.SortFunc(slaves, func(a, b SlaveStatus) int { return a.ID - b.ID })
+	return
+}
+
+// URL returns the URL the server has been configured with.
+func (s *ModbusServer) URL() string {
+	return s.serial.Url
+}
+
+// MemoryMap returns the memory map of the slave, nil if the slave is unknown.
+func (s *ModbusServer) MemoryMap(slaveID int) *modbus.MemoryMap {
+	mm, _, _ := s.slaveState(slaveID)
+	return mm
+}
+
+// slaveState returns the slave's memory map and reports whether the slave is online and whether it is known to the
+// server at all.
+func (s *ModbusServer) slaveState(slaveID int) (mm *modbus.MemoryMap, online bool, known bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	online, known = s.slaves[slaveID]
+	mm = s.memoryMaps[slaveID]
+	return
+}
+
+// onlineSlaves returns the ids of all slaves that are currently online.
+func (s *ModbusServer) onlineSlaves() (slaveIDs []int) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for slaveID, online := range s.slaves {
+		if online {
+			slaveIDs = append(slaveIDs, slaveID)
+		}
+	}
+	return
+}
+
 func (s *ModbusServer) acceptTCPClients() {
 	for {
-		var err error
-		s.sock, err = s.tcpListener.Accept()
+		sock, err := s.tcpListener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			slog.Warn("failed to accept client connection", "error", err)
 			continue
 		}
+
 		ts := time.Now().Format(time.DateTime)
-		text := fmt.Sprintf("%s: client %s connected", ts, s.sock.RemoteAddr())
-		s.logger.Append(text)
-		go s.handleClient()
+		s.lock.Lock()
+		if s.stopping() {
+			s.lock.Unlock()
+			_ = sock.Close()
+			return
+		}
+		if s.maxClients > 0 && len(s.clients) >= s.maxClients {
+			s.lock.Unlock()
+			s.logger.Append(fmt.Sprintf("%s: client %s rejected: max number of clients (%d) reached", ts, sock.RemoteAddr(), s.maxClients))
+			_ = sock.Close()
+			continue
+		}
+		session := &clientSession{sock: sock, transport: &tcpTransport{sock: sock}}
+		if s.scheme == "rtuovertcp" {
+			session.transport = &rtuTCPTransport{sock: sock, idleTimeout: s.idleTimeout}
+		}
+		s.clients[session] = struct{}{}
+		s.lock.Unlock()
+
+		s.logger.Append(fmt.Sprintf("%s: client %s connected", ts, sock.RemoteAddr()))
+		s.goServe(func() { s.handleClient(session) })
+	}
+}
+
+// serveUDP answers each MBAP framed request datagram with a response datagram to its sender until the server is
+// stopped.
+func (s *ModbusServer) serveUDP() {
+	var rxbuf = make([]byte, maxTCPFrameLength)
+	for {
+		n, addr, err := s.udpConn.ReadFrom(rxbuf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Warn("failed to read datagram", "error", err)
+			continue
+		}
+
+		req, txnId, err := readMBAPFrame(bytes.NewReader(rxbuf[:n]))
+		if err != nil {
+			slog.Warn("failed to decode datagram", "client", addr, "error", err)
+			continue
+		}
 
-		// sock.Close()
+		s.loopLock.Lock()
+		if s.stopping() {
+			s.loopLock.Unlock()
+			return
+		}
+		adu, _ := s.serveRequest(req, "", func(res *pdu) []byte { return assembleMBAPFrame(txnId, res) })
+		if adu != nil {
+			if _, err = s.udpConn.WriteTo(adu, addr); err != nil {
+				slog.Warn("failed to send datagram", "client", addr, "error", err)
+			}
+		}
+		s.loopLock.Unlock()
 	}
 }
 
+// closeClient closes the client's socket and removes its session from the server.
+func (s *ModbusServer) closeClient(session *clientSession) {
+	s.lock.Lock()
+	delete(s.clients, session)
+	s.lock.Unlock()
+	_ = session.sock.Close()
+
+	ts := time.Now().Format(time.DateTime)
+	s.logger.Append(fmt.Sprintf("%s: client %s disconnected", ts, session.sock.RemoteAddr()))
+}
+
 type Endianness uint
 type Error string
 
 const (
-	fcReadDiscreteInputs     uint8 = 0x02
-	fcReadInputRegisters     uint8 = 0x04
-	fcWriteSingleRegister    uint8 = 0x06
-	fcWriteMultipleRegisters uint8 = 0x10
-	mbapHeaderLength         int   = 7
+	// coils
+	fcReadCoils          uint8 = 0x01
+	fcWriteSingleCoil    uint8 = 0x05
+	fcWriteMultipleCoils uint8 = 0x0f
+
+	// discrete inputs
+	fcReadDiscreteInputs uint8 = 0x02
+
+	// 16-bit input/holding registers
+	fcReadHoldingRegisters       uint8 = 0x03
+	fcReadInputRegisters         uint8 = 0x04
+	fcWriteSingleRegister        uint8 = 0x06
+	fcWriteMultipleRegisters     uint8 = 0x10
+	fcMaskWriteRegister          uint8 = 0x16
+	fcReadWriteMultipleRegisters uint8 = 0x17
+
+	mbapHeaderLength int = 7
+
+	// exception codes
+	exIllegalFunction         uint8 = 0x01
+	exIllegalDataAddress      uint8 = 0x02
+	exIllegalDataValue        uint8 = 0x03
+	exServerDeviceFailure     uint8 = 0x04
+	exGWPathUnavailable       uint8 = 0x0a
+	exGWTargetFailedToRespond uint8 = 0x0b
 
 	// endianness of 16-bit registers
 	BIG_ENDIAN        Endianness = 1
 	LITTLE_ENDIAN     Endianness = 2
 	maxTCPFrameLength int        = 260
 
-	ErrProtocolError     Error = "protocol error"
-	ErrUnknownProtocolId Error = "unknown protocol identifier"
+	ErrProtocolError           Error = "protocol error"
+	ErrUnknownProtocolId       Error = "unknown protocol identifier"
+	ErrIllegalFunction         Error = "illegal function"
+	ErrIllegalDataAddress      Error = "illegal data address"
+	ErrIllegalDataValue        Error = "illegal data value"
+	ErrServerDeviceFailure     Error = "server device failure"
+	ErrGWPathUnavailable       Error = "gateway path unavailable"
+	ErrGWTargetFailedToRespond Error = "gateway target device failed to respond"
+	ErrServerClosed            Error = "server closed"
+	ErrNotFound                Error = "not found"
+
+	// OfflineSilent and OfflineException are the accepted values of modbus.Serial.OfflineResponse.
+	OfflineSilent    = "silent"
+	OfflineException = "exception"
 )
 
 // Error implements the error interface.
@@ -98,180 +482,244 @@ func (me Error) Error() (s string) {
 	return
 }
 
+// mapErrorToExceptionCode turns an Error into a modbus exception code.
+func mapErrorToExceptionCode(err error) (exceptionCode uint8) {
+	var forced forcedException
+	switch {
+	case errors.Is(err, ErrIllegalFunction):
+		exceptionCode = exIllegalFunction
+	case errors.Is(err, ErrIllegalDataAddress), errors.Is(err, modbus.ErrUnmappedAddress),
+		errors.Is(err, modbus.ErrAddressOutOfRange):
+		exceptionCode = exIllegalDataAddress
+	case errors.Is(err, ErrIllegalDataValue):
+		exceptionCode = exIllegalDataValue
+	case errors.Is(err, ErrGWPathUnavailable):
+		exceptionCode = exGWPathUnavailable
+	case errors.Is(err, ErrGWTargetFailedToRespond):
+		exceptionCode = exGWTargetFailedToRespond
+	case errors.As(err, &forced):
+		exceptionCode = uint8(forced)
+	default:
+		exceptionCode = exServerDeviceFailure
+	}
+
+	return
+}
+
 type pdu struct {
 	unitId       uint8
 	functionCode uint8
 	payload      []byte
+	executed     bool // set by dispatch for requests executed against a memory map
+}
+
+// transport reads requests from and writes responses to a client using a specific framing.
+type transport interface {
+	ReadRequest() (*pdu, error)
+	// EncodeResponse turns the response into the frame sent to the client.
+	EncodeResponse(res *pdu) []byte
+	WriteFrame(adu []byte) error
 }
 
-func (s *ModbusServer) handleClient() (req *pdu, err error) {
+// tcpTransport implements the MBAP framing of modbus TCP.
+type tcpTransport struct {
+	sock      net.Conn
+	lastTxnId uint16
+}
+
+func (t *tcpTransport) ReadRequest() (req *pdu, err error) {
 	var txnId uint16
-	for {
-		req, txnId, err = s.readMBAPFrame()
-		if err != nil || req == nil {
-			continue
-		}
-		ts := time.Now().Format(time.DateTime)
-		payloadToLog := req.payload
-		if len(payloadToLog) > 4 {
-			payloadToLog = payloadToLog[:4]
-		}
-		s.logger.Append(fmt.Sprintf("%s req: slave id: %d fc: %X payload: % X", ts, req.unitId, req.functionCode, payloadToLog))
+	req, txnId, err = readMBAPFrame(t.sock)
+	if err != nil {
+		return
+	}
 
-		// store the incoming transaction id
-		s.lastTxnId = txnId
+	// store the incoming transaction id
+	t.lastTxnId = txnId
+	return
+}
 
-		if !s.slaves[int(req.unitId)] {
-			ts := time.Now().Format(time.DateTime)
-			s.logger.Append(fmt.Sprintf("%s req: slave id: %d is offline", ts, req.unitId))
-			continue
-		}
+func (t *tcpTransport) EncodeResponse(res *pdu) []byte {
+	return assembleMBAPFrame(t.lastTxnId, res)
+}
 
-		if req.functionCode == fcWriteSingleRegister {
-			addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			value := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
-			ts := time.Now().Format(time.DateTime)
-			s.logger.Append(fmt.Sprintf("%s % X %d", ts, addr, value))
+func (t *tcpTransport) WriteFrame(adu []byte) (err error) {
+	_, err = t.sock.Write(adu)
+	return
+}
 
-			s.memoryMap.PutInputReg(addr, value)
+// handleClient serves requests of a single client until the connection fails, the client hangs up, stays idle longer
+// than the configured idle timeout or the server is stopped.
+func (s *ModbusServer) handleClient(session *clientSession) {
+	defer s.closeClient(session)
 
-			res := &pdu{
-				unitId:       req.unitId,
-				functionCode: req.functionCode,
-				payload:      req.payload[0:4],
-			}
-			s.logger.Append(fmt.Sprintf("%s res: slave id: %d fc: %X payload: % X", ts, res.unitId, res.functionCode, res.payload))
-			_, err = s.sock.Write(s.assembleMBAPFrame(s.lastTxnId, res))
-			if err != nil {
-				return
-			}
+	if conn, ok := session.sock.(*tls.Conn); ok {
+		if err := s.authenticate(session, conn); err != nil {
+			slog.Warn("tls handshake failed", "client", session.sock.RemoteAddr(), "error", err)
+			return
 		}
+	}
 
-		if req.functionCode == fcReadDiscreteInputs {
-			//addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	for {
+		if s.idleTimeout > 0 {
+			_ = session.sock.SetDeadline(time.Now().Add(s.idleTimeout))
+		}
 
-			var values = make([]bool, quantity)
-			for i := range int(quantity) {
-				values[i] = rand.Intn(2) == 1
+		req, err := session.transport.ReadRequest()
+		if errors.Is(err, ErrBadCRC) || errors.Is(err, ErrShortFrame) {
+			ts := time.Now().Format(time.DateTime)
+			s.logger.Append(fmt.Sprintf("%s: client %s: frame discarded: %v", ts, session.sock.RemoteAddr(), err))
+			continue
+		}
+		if err != nil {
+			switch {
+			case s.stopping():
+			case errors.Is(err, os.ErrDeadlineExceeded):
+				ts := time.Now().Format(time.DateTime)
+				s.logger.Append(fmt.Sprintf("%s: client %s idle for %s", ts, session.sock.RemoteAddr(), s.idleTimeout))
+			case err != io.EOF:
+				slog.Warn("failed to read request", "client", session.sock.RemoteAddr(), "error", err)
 			}
-			resCount := len(values)
+			return
+		}
 
-			// assemble a response PDU
-			res := &pdu{
-				unitId:       req.unitId,
-				functionCode: req.functionCode,
-				payload:      []byte{0},
-			}
-			// byte count (1 byte for 8 coils)
-			res.payload[0] = uint8(resCount / 8)
-			if resCount%8 != 0 {
-				res.payload[0]++
-			}
+		session.busy.Lock()
+		if s.stopping() {
+			session.busy.Unlock()
+			return
+		}
+		adu, closeConn := s.serveRequest(req, session.role, session.transport.EncodeResponse)
+		if adu != nil {
+			err = session.transport.WriteFrame(adu)
+		}
+		session.busy.Unlock()
+		if err != nil || closeConn {
+			return
+		}
+	}
+}
 
-			// coil values
-			res.payload = append(res.payload, encodeBools(values)...)
+// handleRequest dispatches the request to the function code specific handler and returns the response PDU. Failed
+// requests are answered with an exception response. A nil response means that no response must be sent at all.
+func (s *ModbusServer) handleRequest(req *pdu) (res *pdu) {
+	var err error
 
-			ts := time.Now().Format(time.DateTime)
-			s.logger.Append(fmt.Sprintf("%s res: slave id: %d fc: %X payload: % X", ts, res.unitId, res.functionCode, res.payload))
+	s.logPDU("req", req)
 
-			_, err = s.sock.Write(s.assembleMBAPFrame(s.lastTxnId, res))
-			if err != nil {
-				return
-			}
-		} else if req.functionCode == fcReadInputRegisters {
-			addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
-
-			var values = make([]uint16, quantity)
-			if v, ok := s.memoryMap.GetInputReg(addr); ok {
-				s.logger.Append(fmt.Sprintf("got response: %d", v))
-				values[0] = v
-			} else {
-				// generate random 16-bit register values
-				for i := range int(quantity) {
-					values[i] = uint16(rand.Intn(65536))
-				}
-			}
+	if req.unitId == 0 {
+		s.handleBroadcast(req)
+		return nil
+	}
 
-			// assemble a response PDU
-			res := &pdu{
-				unitId:       req.unitId,
-				functionCode: req.functionCode,
-				payload:      []byte{uint8(quantity * 2)}, // byte count (2 bytes per register)
-			}
+	switch mm, online, known := s.slaveState(int(req.unitId)); {
+	case !known && s.offlineResponse == OfflineException:
+		err = ErrGWPathUnavailable
+	case !online && s.offlineResponse == OfflineException:
+		err = ErrGWTargetFailedToRespond
+	case !online:
+		ts := time.Now().Format(time.DateTime)
+		s.logger.Append(fmt.Sprintf("%s req: slave id: %d is offline", ts, req.unitId))
+		return nil
+	default:
+		res, err = s.dispatch(int(req.unitId), mm, req)
+	}
 
-			// append register values as bytes
-			for _, value := range values {
-				res.payload = append(res.payload, uint16ToBytes(BIG_ENDIAN, value)...)
-			}
+	if err != nil {
+		return s.exceptionResponse(req, err)
+	}
 
-			ts := time.Now().Format(time.DateTime)
+	s.logPDU("res", res)
+	return res
+}
 
-			// Timesync hack
-			timeregAddr := []byte{0x8F, 0xFC}
-			if addr == bytesToUint16(BIG_ENDIAN, timeregAddr) {
-				var syncTime uint64 = 2815470101985099801 // 2025-08-14 15:36
-
-				// Split into 4 words (16-bit each, big endian)
-				word0 := uint16((syncTime >> 48) & 0xFFFF)
-				word1 := uint16((syncTime >> 32) & 0xFFFF)
-				word2 := uint16((syncTime >> 16) & 0xFFFF)
-				word3 := uint16(syncTime & 0xFFFF)
-
-				// Copy the 4 words into the first 8 bytes of res.payload
-				copy(res.payload[1:3], uint16ToBytes(BIG_ENDIAN, word0))
-				copy(res.payload[3:5], uint16ToBytes(BIG_ENDIAN, word1))
-				copy(res.payload[5:7], uint16ToBytes(BIG_ENDIAN, word2))
-				copy(res.payload[7:9], uint16ToBytes(BIG_ENDIAN, word3))
-			}
+// exceptionResponse returns the exception response to req that reports err.
+func (s *ModbusServer) exceptionResponse(req *pdu, err error) (res *pdu) {
+	res = &pdu{
+		unitId:       req.unitId,
+		functionCode: 0x80 | req.functionCode,
+		payload:      []byte{mapErrorToExceptionCode(err)},
+	}
+	ts := time.Now().Format(time.DateTime)
+	s.logger.Append(fmt.Sprintf("%s exc: slave id: %d fc: %X error: %v", ts, req.unitId, req.functionCode, err))
 
-			payloadToLog := res.payload
-			if len(payloadToLog) > 4 {
-				payloadToLog = payloadToLog[:4]
-			}
-			s.logger.Append(fmt.Sprintf("%s res: slave id: %d fc: %X payload: % X", ts, res.unitId, res.functionCode, payloadToLog))
+	s.logPDU("res", res)
+	return res
+}
 
-			_, err = s.sock.Write(s.assembleMBAPFrame(s.lastTxnId, res))
-			if err != nil {
-				return
-			}
-		} else if req.functionCode == fcWriteMultipleRegisters {
-			addr := bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity := bytesToUint16(BIG_ENDIAN, req.payload[2:4])
-			byteCount := req.payload[4]
-
-			// validate byte count
-			if byteCount != uint8(quantity*2) {
-				continue
-			}
+// handleBroadcast applies a write request addressed to unit id 0 to the memory maps of all online slaves. Broadcasts
+// are never answered and requests other than writes are ignored.
+func (s *ModbusServer) handleBroadcast(req *pdu) {
+	switch req.functionCode {
+	case fcWriteSingleCoil, fcWriteMultipleCoils, fcWriteSingleRegister, fcWriteMultipleRegisters, fcMaskWriteRegister:
+	default:
+		ts := time.Now().Format(time.DateTime)
+		s.logger.Append(fmt.Sprintf("%s req: broadcast of fc: %X ignored", ts, req.functionCode))
+		return
+	}
 
-			// log the write operation
+	for _, slaveID := range s.onlineSlaves() {
+		mm, _, _ := s.slaveState(slaveID)
+		if _, err := s.dispatch(slaveID, mm, req); err != nil {
 			ts := time.Now().Format(time.DateTime)
-			s.logger.Append(fmt.Sprintf("%s write: slave id: %d addr: %d quantity: %d", ts, req.unitId, addr, quantity))
+			s.logger.Append(fmt.Sprintf("%s exc: broadcast fc: %X error: %v", ts, req.functionCode, err))
+			return
+		}
+	}
+}
 
-			// assemble response PDU (echo back addr and quantity)
-			res := &pdu{
-				unitId:       req.unitId,
-				functionCode: req.functionCode,
-				payload:      make([]byte, 4),
-			}
-			copy(res.payload[0:2], uint16ToBytes(BIG_ENDIAN, addr))
-			copy(res.payload[2:4], uint16ToBytes(BIG_ENDIAN, quantity))
+// dispatch passes the request to the handler of its function code, which executes it against the slave's memory map
+// mm. The slave's clocks are refreshed before and set by writes to their registers after the request, writes are
+// checked against the slave's register definitions before and run the slave's behaviour script after the request.
+func (s *ModbusServer) dispatch(slaveID int, mm *modbus.MemoryMap, req *pdu) (res *pdu, err error) {
+	s.refreshClocks(slaveID, mm)
+	if err = s.checkWrite(slaveID, mm, req); err != nil {
+		return
+	}
 
-			ts = time.Now().Format(time.DateTime)
-			s.logger.Append(fmt.Sprintf("%s res: slave id: %d fc: %X payload: % X", ts, res.unitId, res.functionCode, res.payload))
+	switch req.functionCode {
+	case fcReadCoils, fcReadDiscreteInputs:
+		res, err = s.readBits(mm, req)
+	case fcReadHoldingRegisters, fcReadInputRegisters:
+		res, err = s.readRegisters(mm, req)
+	case fcWriteSingleCoil:
+		res, err = s.writeSingleCoil(mm, req)
+	case fcWriteMultipleCoils:
+		res, err = s.writeMultipleCoils(mm, req)
+	case fcWriteSingleRegister:
+		res, err = s.writeSingleRegister(mm, req)
+	case fcWriteMultipleRegisters:
+		res, err = s.writeMultipleRegisters(mm, req)
+	case fcMaskWriteRegister:
+		res, err = s.maskWriteRegister(mm, req)
+	case fcReadWriteMultipleRegisters:
+		res, err = s.readWriteMultipleRegisters(mm, req)
+	default:
+		err = ErrIllegalFunction
+	}
 
-			_, err = s.sock.Write(s.assembleMBAPFrame(s.lastTxnId, res))
-			if err != nil {
-				return
-			}
-		}
+	if addr, quantity, ok := writtenRange(req); ok && err == nil {
+		s.setClocks(slaveID, mm, addr, quantity)
+	}
+	if err == nil {
+		req.executed = true
+		s.applyBehaviour(slaveID, mm, req)
 	}
+
+	return
+}
+
+// logPDU appends the first bytes of the PDU's payload to the server log.
+func (s *ModbusServer) logPDU(direction string, p *pdu) {
+	payloadToLog := p.payload
+	if len(payloadToLog) > 4 {
+		payloadToLog = payloadToLog[:4]
+	}
+	ts := time.Now().Format(time.DateTime)
+	s.logger.Append(fmt.Sprintf("%s %s: slave id: %d fc: %X payload: % X", ts, direction, p.unitId, p.functionCode, payloadToLog))
 }
 
 // Reads an entire frame (MBAP header + modbus PDU) from the socket.
-func (s *ModbusServer) readMBAPFrame() (p *pdu, txnId uint16, err error) {
+func readMBAPFrame(sock io.Reader) (p *pdu, txnId uint16, err error) {
 	var rxbuf []byte
 	var bytesNeeded int
 	var protocolId uint16
@@ -279,7 +727,7 @@ func (s *ModbusServer) readMBAPFrame() (p *pdu, txnId uint16, err error) {
 
 	// read the MBAP header
 	rxbuf = make([]byte, mbapHeaderLength)
-	_, err = io.ReadFull(s.sock, rxbuf)
+	_, err = io.ReadFull(sock, rxbuf)
 	if err != nil {
 		return
 	}
@@ -311,7 +759,7 @@ func (s *ModbusServer) readMBAPFrame() (p *pdu, txnId uint16, err error) {
 
 	// read the PDU
 	rxbuf = make([]byte, bytesNeeded)
-	_, err = io.ReadFull(s.sock, rxbuf)
+	_, err = io.ReadFull(sock, rxbuf)
 	if err != nil {
 		return
 	}
@@ -319,7 +767,7 @@ func (s *ModbusServer) readMBAPFrame() (p *pdu, txnId uint16, err error) {
 	// validate the protocol identifier
 	if protocolId != 0x0000 {
 		err = ErrUnknownProtocolId
-		slog.Warn("received unexpected protocol id 0x%04x", protocolId)
+		slog.Warn("received unexpected protocol id", "protocolId", fmt.Sprintf("0x%04x", protocolId))
 		return
 	}
 
@@ -334,7 +782,7 @@ func (s *ModbusServer) readMBAPFrame() (p *pdu, txnId uint16, err error) {
 }
 
 // Turns a PDU into an MBAP frame (MBAP header + PDU) and returns it as bytes.
-func (s *ModbusServer) assembleMBAPFrame(txnId uint16, p *pdu) (payload []byte) {
+func assembleMBAPFrame(txnId uint16, p *pdu) (payload []byte) {
 	// transaction identifier
 	payload = uint16ToBytes(BIG_ENDIAN, txnId)
 	// protocol identifier (always 0x0000)
@@ -392,3 +840,21 @@ func encodeBools(in []bool) (out []byte) {
 
 	return
 }
+
+func bytesToUint16s(endianness Endianness, in []byte) (out []uint16) {
+	for i := 0; i+1 < len(in); i += 2 {
+		out = append(out, bytesToUint16(endianness, in[i:i+2]))
+	}
+
+	return
+}
+
+func decodeBools(quantity uint16, in []byte) (out []bool) {
+	var i uint
+
+	for i = range uint(quantity) {
+		out = append(out, ((in[i/8]>>(i%8))&0x01) == 0x01)
+	}
+
+	return
+}
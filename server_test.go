@@ -0,0 +1,267 @@
+package modsimpro
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// mbapRequest sends the request to conn in an MBAP frame and returns the response.
+func mbapRequest(t *testing.T, conn net.Conn, unitID uint8, functionCode uint8, payload ...byte) *pdu {
+	t.Helper()
+	if _, err := conn.Write(assembleMBAPFrame(0x1234, &pdu{unitId: unitID, functionCode: functionCode, payload: payload})); err != nil {
+		t.Fatal(err)
+	}
+	res, txnID, err := readMBAPFrame(conn)
+	if err != nil {
+		t.Fatalf("fc %X: %v", functionCode, err)
+	}
+	if txnID != 0x1234 || res.unitId != unitID {
+		t.Fatalf("fc %X: got transaction id %X, unit id %d", functionCode, txnID, res.unitId)
+	}
+	return res
+}
+
+// expectResponse compares the function code and payload of res with the wanted ones.
+func expectResponse(t *testing.T, name string, res *pdu, functionCode uint8, payload ...byte) {
+	t.Helper()
+	if res.functionCode != functionCode || !bytes.Equal(res.payload, payload) {
+		t.Errorf("%s: got fc %X % X, want fc %X % X", name, res.functionCode, res.payload, functionCode, payload)
+	}
+}
+
+// expectSilence sends the request to conn and makes sure that it isn't answered.
+func expectSilence(t *testing.T, conn net.Conn, unitID uint8, functionCode uint8, payload ...byte) {
+	t.Helper()
+	if _, err := conn.Write(assembleMBAPFrame(1, &pdu{unitId: unitID, functionCode: functionCode, payload: payload})); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	defer conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("unit id %d fc %X: got %v, want no response", unitID, functionCode, err)
+	}
+}
+
+func TestExceptionResponses(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0"})
+	conn := dial(t, s, "tcp")
+
+	expectResponse(t, "unknown function", mbapRequest(t, conn, 1, 0x2B, 0x0E, 0x01, 0x00), 0xAB, exIllegalFunction)
+	expectResponse(t, "illegal data value", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0x00, 0x00, 0x00, 0x00),
+		0x83, exIllegalDataValue)
+	expectResponse(t, "illegal data address", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0xFF, 0xFF, 0x00, 0x02),
+		0x83, exIllegalDataAddress)
+
+	// offline and unknown slaves stay silent by default
+	s.Disconnect(1)
+	expectSilence(t, conn, 1, fcReadHoldingRegisters, 0x00, 0x00, 0x00, 0x01)
+	expectSilence(t, conn, 2, fcReadHoldingRegisters, 0x00, 0x00, 0x00, 0x01)
+}
+
+func TestOfflineException(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0", OfflineResponse: OfflineException})
+	conn := dial(t, s, "tcp")
+
+	s.Disconnect(1)
+	expectResponse(t, "offline slave", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0x00, 0x00, 0x00, 0x01),
+		0x83, exGWTargetFailedToRespond)
+	expectResponse(t, "unknown slave", mbapRequest(t, conn, 2, fcWriteSingleCoil, 0x00, 0x00, 0xFF, 0x00),
+		0x85, exGWPathUnavailable)
+
+	s.Connect(1)
+	expectResponse(t, "online slave", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0x00, 0x00, 0x00, 0x01),
+		fcReadHoldingRegisters, 0x02, 0x00, 0x00)
+}
+
+func TestUnmappedPolicy(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0", Unmapped: "exception"})
+	s.MemoryMap(1).PutHoldingReg(0x10, 42)
+	conn := dial(t, s, "tcp")
+
+	expectResponse(t, "mapped register", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0, 0x10, 0, 1),
+		fcReadHoldingRegisters, 2, 0, 42)
+	expectResponse(t, "partly unmapped range", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0, 0x10, 0, 2),
+		0x83, exIllegalDataAddress)
+	expectResponse(t, "unmapped input register", mbapRequest(t, conn, 1, fcReadInputRegisters, 0, 0x10, 0, 1),
+		0x84, exIllegalDataAddress)
+	expectResponse(t, "unmapped coil", mbapRequest(t, conn, 1, fcReadCoils, 0, 0, 0, 1), 0x81, exIllegalDataAddress)
+
+	// writes map the addresses
+	mbapRequest(t, conn, 1, fcWriteSingleRegister, 0, 0x11, 0, 7)
+	expectResponse(t, "written register", mbapRequest(t, conn, 1, fcReadHoldingRegisters, 0, 0x10, 0, 2),
+		fcReadHoldingRegisters, 4, 0, 42, 0, 7)
+
+	// the policy is per server, other servers read zeros
+	s = startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0"})
+	conn = dial(t, s, "tcp")
+	expectResponse(t, "zero policy", mbapRequest(t, conn, 1, fcReadInputRegisters, 0, 0x10, 0, 1),
+		fcReadInputRegisters, 2, 0, 0)
+}
+
+func TestSlavesHaveSeparateMemoryMaps(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0", Slaves: []modbus.Slave{{Address: 101}, {Address: 102}}})
+	conn := dial(t, s, "tcp")
+
+	mbapRequest(t, conn, 101, fcWriteSingleRegister, 0, 0x10, 0, 7)
+	expectResponse(t, "slave 101", mbapRequest(t, conn, 101, fcReadHoldingRegisters, 0, 0x10, 0, 1), fcReadHoldingRegisters, 2, 0, 7)
+	expectResponse(t, "slave 102", mbapRequest(t, conn, 102, fcReadHoldingRegisters, 0, 0x10, 0, 1), fcReadHoldingRegisters, 2, 0, 0)
+}
+
+func TestBroadcast(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0", Slaves: []modbus.Slave{{Address: 1}, {Address: 2}, {Address: 3}}})
+	s.Disconnect(3)
+	conn := dial(t, s, "tcp")
+
+	// broadcasts are executed by all online slaves and never answered
+	expectSilence(t, conn, 0, fcWriteMultipleRegisters, 0, 0x10, 0, 2, 4, 0, 1, 0, 2)
+	expectSilence(t, conn, 0, fcWriteSingleCoil, 0, 3, 0xFF, 0)
+	expectSilence(t, conn, 0, fcReadHoldingRegisters, 0, 0x10, 0, 1)
+
+	for _, tt := range []struct {
+		slaveID int
+		want    uint16
+		coil    bool
+	}{
+		{1, 2, true},
+		{2, 2, true},
+		{3, 0, false},
+	} {
+		mm := s.MemoryMap(tt.slaveID)
+		if v, _ := mm.GetHoldingReg(0x11); v != tt.want {
+			t.Errorf("slave %d: got holding 0x11 = %d, want %d", tt.slaveID, v, tt.want)
+		}
+		if v, _ := mm.GetCoil(3); v != tt.coil {
+			t.Errorf("slave %d: got coil 3 = %v, want %v", tt.slaveID, v, tt.coil)
+		}
+	}
+}
+
+func TestStopWithIdleClients(t *testing.T) {
+	for url, network := range map[string]string{"tcp://127.0.0.1:0": "tcp", "rtuovertcp://127.0.0.1:0": "tcp", "udp://127.0.0.1:0": "udp"} {
+		s := startServer(t, modbus.Serial{Url: url})
+		conn := dial(t, s, network)
+
+		start := time.Now()
+		if err := s.Stop(context.Background()); err != nil {
+			t.Errorf("%s: %v", url, err)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("%s: stop took %v", url, d)
+		}
+		if network == "tcp" {
+			if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("%s: got %v, want the connection closed", url, err)
+			}
+		}
+	}
+}
+
+func TestStopWaitsForRequestsInProgress(t *testing.T) {
+	s := startServer(t, modbus.Serial{Url: "tcp://127.0.0.1:0"})
+	if _, err := s.AddFault(1, modbus.FaultConfig{Type: modbus.FaultDelay, Delay: 200}); err != nil {
+		t.Fatal(err)
+	}
+	conn := dial(t, s, "tcp")
+	if _, err := conn.Write(assembleMBAPFrame(0x1234, &pdu{unitId: 1, functionCode: fcWriteSingleRegister, payload: []byte{0, 0x10, 0, 7}})); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("stop returned after %v, before the request completed", d)
+	}
+	// the response is sent before the connection is closed
+	res, _, err := readMBAPFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectResponse(t, "request in progress", res, fcWriteSingleRegister, 0, 0x10, 0, 7)
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want the connection closed", err)
+	}
+}
+
+// discardLogger drops the log of servers whose goroutines outlive the test.
+type discardLogger struct{}
+
+func (discardLogger) Append(string) {}
+
+func TestStopExpires(t *testing.T) {
+	s := NewModbusServer(modbus.Serial{Url: "tcp://127.0.0.1:0"}, discardLogger{})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.Connect(1)
+	if _, err := s.AddFault(1, modbus.FaultConfig{Type: modbus.FaultDelay, Delay: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	conn := dial(t, s, "tcp")
+	if _, err := conn.Write(assembleMBAPFrame(0x1234, &pdu{unitId: 1, functionCode: fcReadHoldingRegisters, payload: []byte{0, 0, 0, 1}})); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("stop took %v", d)
+	}
+	// the connection is closed without waiting for the response
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want the connection closed", err)
+	}
+}
+
+func TestServe(t *testing.T) {
+	// find a free port, the server's address isn't known before Serve has started it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	s := NewModbusServer(modbus.Serial{Url: "tcp://" + addr}, testLogger{t})
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- s.Serve(ctx) }()
+
+	var conn net.Conn
+	for deadline := time.Now().Add(time.Second); conn == nil; {
+		if conn, err = net.Dial("tcp", addr); err != nil && time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("serve didn't return on cancel")
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got %v, want the connection closed", err)
+	}
+}
@@ -0,0 +1,123 @@
+package modsimpro
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// Snapshot is the state of a set of servers at a point in time.
+type Snapshot struct {
+	Time    time.Time        `json:"time"`
+	Servers []ServerSnapshot `json:"servers"`
+}
+
+// ServerSnapshot is the state of a server's slaves. Servers are identified by their configured URL.
+type ServerSnapshot struct {
+	URL    string          `json:"url"`
+	Slaves []SlaveSnapshot `json:"slaves"`
+}
+
+// SlaveSnapshot is the online flag and the memory content of a slave.
+type SlaveSnapshot struct {
+	ID     int                   `json:"id"`
+	Online bool                  `json:"online"`
+	Memory modbus.MemorySnapshot `json:"memory"`
+}
+
+// Snapshot returns the state of all slaves known to the server, ordered by id.
+func (s *ModbusServer) Snapshot() ServerSnapshot {
+	snapshot := ServerSnapshot{URL: s.URL()}
+	for _, status := range s.Slaves() {
+		slave := SlaveSnapshot{ID: status.ID, Online: status.Online}
+		if mm := s.MemoryMap(status.ID); mm != nil {
+			slave.Memory = mm.Snapshot()
+		}
+		snapshot.Slaves = append(snapshot.Slaves, slave)
+	}
+	return snapshot
+}
+
+// Restore sets the memory content and the online flag of the snapshot's slaves. Slaves unknown to the server are
+// added to it, slaves missing from the snapshot are left unchanged.
+func (s *ModbusServer) Restore(snapshot ServerSnapshot) error {
+	for _, slave := range snapshot.Slaves {
+		s.lock.Lock()
+		s.slaves[slave.ID] = slave.Online
+		mm, ok := s.memoryMaps[slave.ID]
+		if !ok {
+			mm = s.newMemoryMap()
+			s.memoryMaps[slave.ID] = mm
+		}
+		s.lock.Unlock()
+
+		if err := mm.Restore(slave.Memory); err != nil {
+			return fmt.Errorf("slave %d: %w", slave.ID, err)
+		}
+	}
+	return nil
+}
+
+// SaveSnapshot writes the state of the servers to the JSON file at path. The file is replaced atomically, so that an
+// interrupted save leaves the previous snapshot intact.
+func SaveSnapshot(path string, servers []*ModbusServer) error {
+	snapshot := Snapshot{Time: time.Now()}
+	for _, s := range servers {
+		snapshot.Servers = append(snapshot.Servers, s.Snapshot())
+	}
+	bb, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(bb); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadSnapshot reads the snapshot file at path.
+func LoadSnapshot(path string) (Snapshot, error) {
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	var snapshot Snapshot
+	if err := json.NewDecoder(bytes.NewReader(bb)).Decode(&snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// RestoreSnapshot restores the state of each server from the snapshot's server with the same URL. Servers of the
+// snapshot that aren't among the servers are reported with ErrNotFound, the others are restored nevertheless.
+func RestoreSnapshot(snapshot Snapshot, servers []*ModbusServer) error {
+	var errs []error
+	for _, ss := range snapshot.Servers {
+		i := slices.IndexFunc(servers, func(s *ModbusServer) bool { return s.URL() == ss.URL })
+		if i < 0 {
+			errs = append(errs, fmt.Errorf("server %s: %w", ss.URL, ErrNotFound))
+			continue
+		}
+		if err := servers[i].Restore(ss); err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", ss.URL, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,106 @@
+package modsimpro
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+)
+
+// roleOID identifies the certificate extension carrying the client's role as defined by the modbus/TCP security
+// specification.
+var roleOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+
+// tlsHandshakeTimeout limits the time a client may take to complete the TLS handshake.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// serverTLSConfig returns a TLS configuration that presents the configured server certificate and requires clients
+// to authenticate with a certificate signed by the configured CA.
+func serverTLSConfig(serial modbus.Serial) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(serial.TLSCert, serial.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+
+	clientCAs, err := loadCertPool(serial.TLSCA)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// loadCertPool loads the PEM encoded certificates of a file into a CertPool.
+func loadCertPool(name string) (*x509.CertPool, error) {
+	bb, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bb) {
+		return nil, fmt.Errorf("%s: no certificate found", name)
+	}
+	return pool, nil
+}
+
+// authenticate completes the TLS handshake and stores the role of the client's certificate in the session.
+func (s *ModbusServer) authenticate(session *clientSession, conn *tls.Conn) (err error) {
+	_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err = conn.Handshake(); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		if session.role, err = certificateRole(certs[0]); err != nil {
+			return
+		}
+	}
+
+	ts := time.Now().Format(time.DateTime)
+	s.logger.Append(fmt.Sprintf("%s: client %s authenticated, role: %q", ts, conn.RemoteAddr(), session.role))
+	return
+}
+
+// certificateRole returns the role stored in the certificate's role extension or "" if there is none.
+func certificateRole(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(roleOID) {
+			continue
+		}
+		var role string
+		if _, err := asn1.UnmarshalWithParams(ext.Value, &role, "utf8"); err != nil {
+			return "", fmt.Errorf("invalid role extension: %w", err)
+		}
+		return role, nil
+	}
+	return "", nil
+}
+
+// mayWrite reports whether a client with the given role may send write requests. Write roles only apply to tcp+tls,
+// without configured write roles everybody may write.
+func (s *ModbusServer) mayWrite(role string) bool {
+	return s.scheme != "tcp+tls" || len(s.serial.TLSWriteRoles) == 0 || slices.Contains(s.serial.TLSWriteRoles, role)
+}
+
+// isWriteFunction reports whether the function code modifies the memory map.
+func isWriteFunction(functionCode uint8) bool {
+	switch functionCode {
+	case fcWriteSingleCoil, fcWriteMultipleCoils, fcWriteSingleRegister, fcWriteMultipleRegisters,
+		fcMaskWriteRegister, fcReadWriteMultipleRegisters:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,244 @@
+package modsimpro
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rwirdemann/modsimpro/modbus"
+	sv "github.com/simonvetter/modbus"
+)
+
+// testCA is a certificate authority issuing the server and client certificates of a test.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "modsimpro test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a certificate signed by the CA. A non-empty role is stored in the role extension, the server
+// certificate is valid for 127.0.0.1.
+func (ca *testCA) issue(t *testing.T, name string, role string, server bool) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+	if role != "" {
+		value, err := asn1.MarshalWithParams(role, "utf8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: roleOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writePEM writes the certificate and its key to PEM files in dir and returns their paths.
+func writePEM(t *testing.T, dir string, cert tls.Certificate) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+type testLogger struct{ t *testing.T }
+
+func (l testLogger) Append(s string) { l.t.Log(s) }
+
+// startTLSServer starts a tcp+tls server for slave 1 that trusts ca and lets clients with one of writeRoles write.
+func startTLSServer(t *testing.T, ca *testCA, writeRoles ...string) *ModbusServer {
+	t.Helper()
+	dir := t.TempDir()
+	certFile, keyFile := writePEM(t, dir, ca.issue(t, "server", "", true))
+	caFile := filepath.Join(dir, "ca.crt")
+	if err := os.WriteFile(caFile, ca.pem, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewModbusServer(modbus.Serial{
+		Url:           "tcp+tls://127.0.0.1:0",
+		TLSCert:       certFile,
+		TLSKey:        keyFile,
+		TLSCA:         caFile,
+		TLSWriteRoles: writeRoles,
+		Slaves:        []modbus.Slave{{Address: 1}},
+	}, testLogger{t})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	s.Connect(1)
+	return s
+}
+
+func newTLSClient(t *testing.T, s *ModbusServer, serverCA *testCA, cert tls.Certificate) *sv.ModbusClient {
+	t.Helper()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(serverCA.cert)
+	client, err := sv.NewClient(&sv.ClientConfiguration{
+		URL:           "tcp+tls://" + s.Addr().String(),
+		Timeout:       time.Second,
+		TLSClientCert: &cert,
+		TLSRootCAs:    rootCAs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestTLSWriteRoles(t *testing.T) {
+	ca := newTestCA(t)
+	s := startTLSServer(t, ca, "operator")
+
+	tests := []struct {
+		name     string
+		role     string
+		mayWrite bool
+	}{
+		{"operator", "operator", true},
+		{"viewer", "viewer", false},
+		{"no role", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTLSClient(t, s, ca, ca.issue(t, tt.name, tt.role, false))
+			if err := client.Open(); err != nil {
+				t.Fatal(err)
+			}
+			_ = client.SetUnitId(1)
+
+			if _, err := client.ReadRegisters(0x10, 2, sv.HOLDING_REGISTER); err != nil {
+				t.Errorf("read: %v", err)
+			}
+			err := client.WriteRegister(0x10, 7)
+			switch {
+			case tt.mayWrite && err != nil:
+				t.Errorf("write: %v", err)
+			case !tt.mayWrite && !errors.Is(err, sv.ErrIllegalFunction):
+				t.Errorf("write: got %v, want illegal function", err)
+			}
+			if err := client.WriteCoil(3, true); !tt.mayWrite && !errors.Is(err, sv.ErrIllegalFunction) {
+				t.Errorf("write coil: got %v, want illegal function", err)
+			}
+		})
+	}
+
+	regs, err := s.MemoryMap(1).ReadHoldingRegs(0x10, 1)
+	if err != nil || regs[0] != 7 {
+		t.Errorf("got holding 0x10 = %v, %v, want 7", regs, err)
+	}
+}
+
+func TestTLSWithoutWriteRoles(t *testing.T) {
+	ca := newTestCA(t)
+	s := startTLSServer(t, ca)
+
+	client := newTLSClient(t, s, ca, ca.issue(t, "client", "", false))
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	_ = client.SetUnitId(1)
+	if err := client.WriteRegister(0x10, 7); err != nil {
+		t.Errorf("write without write roles: %v", err)
+	}
+}
+
+func TestTLSRejectsUntrustedClient(t *testing.T) {
+	ca := newTestCA(t)
+	s := startTLSServer(t, ca, "operator")
+
+	// a certificate with a permitted role but signed by another CA
+	cert := newTestCA(t).issue(t, "intruder", "operator", false)
+	client := newTLSClient(t, s, ca, cert)
+	// with TLS 1.3 the client may finish its side of the handshake before the server rejects the certificate
+	if err := client.Open(); err == nil {
+		_ = client.SetUnitId(1)
+		if _, err := client.ReadRegisters(0x10, 1, sv.HOLDING_REGISTER); err == nil {
+			t.Fatal("untrusted client was served")
+		}
+	}
+}
+
+func TestCertificateRole(t *testing.T) {
+	ca := newTestCA(t)
+	for _, role := range []string{"operator", ""} {
+		cert, err := x509.ParseCertificate(ca.issue(t, "client", role, false).Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := certificateRole(cert); err != nil || got != role {
+			t.Errorf("got role %q, %v, want %q", got, err, role)
+		}
+	}
+
+	invalid := &x509.Certificate{Extensions: []pkix.Extension{{Id: roleOID, Value: []byte{0x02, 0x01, 0x01}}}}
+	if _, err := certificateRole(invalid); err == nil {
+		t.Error("invalid role extension accepted")
+	}
+}